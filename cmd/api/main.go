@@ -1,16 +1,23 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log"
+	"os"
+	"os/signal"
 	"simple-chat-app/internal/server"
+	"syscall"
 )
 
 func main() {
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	server := server.NewServer()
 
-	err := server.ListenAndServe()
-	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+	if err := server.Run(ctx); err != nil {
+		log.Printf("server error: %s", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/database"
+	"simple-chat-app/internal/export"
+	"simple-chat-app/internal/service"
+)
+
+// runExport writes the transcript of the conversation named in args to a file or stdout.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", export.FormatJSONL, "jsonl, csv, html or text")
+	tz := flags.String("tz", "UTC", "time zone of the times written, such as Europe/Paris")
+	from := flags.String("from", "", "only messages from this date or RFC 3339 time")
+	to := flags.String("to", "", "only messages up to this date (inclusive) or RFC 3339 time (exclusive)")
+	output := flags.String("o", "", "file to write the transcript to, stdout by default")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: chatctl export [flags] <conversationId>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	convID, err := primitive.ObjectIDFromHex(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid conversation ID %q", flags.Arg(0))
+	}
+
+	opts := service.ExportOptions{Format: *format}
+	if !export.ValidFormat(opts.Format) {
+		return fmt.Errorf("unknown format %q, use jsonl, csv, html or text", opts.Format)
+	}
+	if opts.Location, err = export.ParseLocation(*tz); err != nil {
+		return err
+	}
+	if opts.From, opts.To, err = export.ParseRange(*from, *to, opts.Location); err != nil {
+		return err
+	}
+
+	db, err := database.New()
+	if err != nil {
+		return fmt.Errorf("error initializing database: %v", err)
+	}
+	defer db.Client().Disconnect(context.Background())
+
+	ctx := context.Background()
+	conversation, err := service.NewConversationService(db).FindById(ctx, convID)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return service.NewExportService(db).Export(ctx, w, conversation, opts)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"simple-chat-app/internal/database"
+	"simple-chat-app/internal/migration"
+)
+
+// runMigrate lists the migrations or runs the one named in args.
+func runMigrate(args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	if args[0] == "list" {
+		for _, m := range migration.All() {
+			fmt.Printf("%-30s %s\n", m.Name, m.Description)
+		}
+		return nil
+	}
+
+	m, err := migration.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New()
+	if err != nil {
+		return fmt.Errorf("error initializing database: %v", err)
+	}
+	defer db.Client().Disconnect(context.Background())
+
+	summary, err := m.Run(context.Background(), db)
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %v", m.Name, err)
+	}
+	fmt.Printf("%s: %s\n", m.Name, summary)
+	return nil
+}
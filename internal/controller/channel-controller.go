@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+	"simple-chat-app/internal/websocket"
+)
+
+type ChannelController struct {
+	channelService *service.ChannelService
+	notifier       ConversationNotifier
+}
+
+func NewChannelController(channelService *service.ChannelService, notifier ConversationNotifier) *ChannelController {
+	return &ChannelController{
+		channelService: channelService,
+		notifier:       notifier,
+	}
+}
+
+type createChannelRequest struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+	Visibility string `json:"visibility"`
+}
+
+// CreateChannelHandler creates a channel owned by the authenticated user.
+func (controller *ChannelController) CreateChannelHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req createChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	channel, err := controller.channelService.Create(c.Request.Context(), userID, req.Name, req.Topic, req.Visibility)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"conversation": channel})
+}
+
+// BrowseChannelsHandler lists the public channels, optionally searched by name or topic
+// with the q query parameter. Pass the nextCursor of a page as the cursor query
+// parameter to get the next one; limit sets the page size.
+func (controller *ChannelController) BrowseChannelsHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			c.Error(utils.NewBadRequestError("limit must be a positive number"))
+			return
+		}
+	}
+
+	page, err := controller.channelService.Browse(c.Request.Context(), userID, c.Query("q"), c.Query("cursor"), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// JoinChannelHandler adds the authenticated user to a public channel.
+func (controller *ChannelController) JoinChannelHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	channel, joined, err := controller.channelService.Join(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if joined {
+		controller.notify(channel, websocket.MembershipJoined, userID)
+	}
+	c.JSON(http.StatusOK, gin.H{"conversation": channel})
+}
+
+// LeaveChannelHandler removes the authenticated user from a channel.
+func (controller *ChannelController) LeaveChannelHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	channel, err := controller.channelService.Leave(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(channel, websocket.MembershipLeft, userID)
+	c.JSON(http.StatusOK, gin.H{"conversation": channel})
+}
+
+// notify tells the channel's members that the user joined or left.
+func (controller *ChannelController) notify(channel *model.Conversation, action string, userID primitive.ObjectID) {
+	if err := controller.notifier.NotifyMembership(channel, action, userID, []primitive.ObjectID{userID}); err != nil {
+		log.Printf("Error delivering membership event: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/utils"
+)
+
+// currentUserID returns the authenticated user set on the context by middleware.VerifyToken.
+func currentUserID(c *gin.Context) (primitive.ObjectID, error) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		return primitive.NilObjectID, utils.NewUnauthenticatedError("Unauthorized")
+	}
+
+	userIDStr, ok := userID.(string)
+	if !ok {
+		return primitive.NilObjectID, utils.NewUnauthenticatedError("Invalid user ID")
+	}
+
+	id, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, utils.NewUnauthenticatedError("Invalid user ID")
+	}
+	return id, nil
+}
+
+// paramObjectID parses the named path parameter as an ObjectID.
+func paramObjectID(c *gin.Context, name string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param(name))
+	if err != nil {
+		return primitive.NilObjectID, utils.NewBadRequestError(fmt.Sprintf("%s must be a valid ID", name))
+	}
+	return id, nil
+}
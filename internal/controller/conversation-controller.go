@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+	"simple-chat-app/internal/websocket"
+)
+
+// ConversationNotifier tells connected clients about conversation changes made over REST.
+type ConversationNotifier interface {
+	NotifyMembership(conversation *model.Conversation, action string, actorID primitive.ObjectID, userIDs []primitive.ObjectID) error
+	NotifySettings(userID, conversationID primitive.ObjectID, settings *model.ParticipantSettings) error
+}
+
+type ConversationController struct {
+	conversationService *service.ConversationService
+	notifier            ConversationNotifier
+}
+
+func NewConversationController(conversationService *service.ConversationService, notifier ConversationNotifier) *ConversationController {
+	return &ConversationController{
+		conversationService: conversationService,
+		notifier:            notifier,
+	}
+}
+
+type createGroupRequest struct {
+	Title   string               `json:"title"`
+	Avatar  string               `json:"avatar"`
+	UserIds []primitive.ObjectID `json:"userIds"`
+}
+
+type addParticipantsRequest struct {
+	UserIds []primitive.ObjectID `json:"userIds"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type muteMemberRequest struct {
+	Duration string `json:"duration"`
+}
+
+// ListConversationsHandler returns the authenticated user's inbox: pinned conversations
+// first, then the most recently active. archived=true lists the archived conversations
+// instead, and pinned=true only the pinned ones. Pass the nextCursor of a page as the
+// cursor query parameter to get the next one; limit sets the page size.
+func (controller *ConversationController) ListConversationsHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	query := service.InboxQuery{
+		Cursor:   c.Query("cursor"),
+		Archived: c.Query("archived") == "true",
+		Pinned:   c.Query("pinned") == "true",
+	}
+	if raw := c.Query("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil || query.Limit <= 0 {
+			c.Error(utils.NewBadRequestError("limit must be a positive number"))
+			return
+		}
+	}
+
+	page, err := controller.conversationService.Inbox(c.Request.Context(), userID, query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// CreateGroupHandler creates a group owned by the authenticated user.
+func (controller *ConversationController) CreateGroupHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req createGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.CreateGroup(c.Request.Context(), userID, req.Title, req.Avatar, req.UserIds)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipCreated, userID, conversation.ParticipantIDs())
+	c.JSON(http.StatusCreated, gin.H{"conversation": conversation})
+}
+
+// AddParticipantsHandler adds users to a group.
+func (controller *ConversationController) AddParticipantsHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req addParticipantsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.AddParticipants(c.Request.Context(), convID, userID, req.UserIds)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipAdded, userID, req.UserIds)
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// RemoveParticipantHandler removes a user from a group.
+func (controller *ConversationController) RemoveParticipantHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	targetID, err := paramObjectID(c, "userId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.RemoveParticipant(c.Request.Context(), convID, userID, targetID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipRemoved, userID, []primitive.ObjectID{targetID})
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// LeaveHandler removes the authenticated user from a group.
+func (controller *ConversationController) LeaveHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.Leave(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipLeft, userID, []primitive.ObjectID{userID})
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// SetRoleHandler changes the role of a group participant.
+func (controller *ConversationController) SetRoleHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	targetID, err := paramObjectID(c, "userId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req setRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.SetRole(c.Request.Context(), convID, userID, targetID, req.Role)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipRoleChanged, userID, []primitive.ObjectID{targetID})
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// SetModerationHandler changes the slow mode and announcements-only rules of a group or channel.
+func (controller *ConversationController) SetModerationHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req service.ModerationUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.SetModeration(c.Request.Context(), convID, userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipModeration, userID, nil)
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// MuteMemberHandler keeps a participant from posting for a duration such as "1h";
+// a duration of "0s" unmutes them.
+func (controller *ConversationController) MuteMemberHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	targetID, err := paramObjectID(c, "userId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req muteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		c.Error(utils.NewBadRequestError("duration must be a duration such as 1h"))
+		return
+	}
+
+	conversation, err := controller.conversationService.MuteMember(c.Request.Context(), convID, userID, targetID, duration)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	controller.notify(conversation, websocket.MembershipMuted, userID, []primitive.ObjectID{targetID})
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// UpdateSettingsHandler changes the authenticated user's settings for a conversation
+// and syncs them to the user's connected devices.
+func (controller *ConversationController) UpdateSettingsHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req service.SettingsUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	settings, err := controller.conversationService.UpdateSettings(c.Request.Context(), convID, userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.notifier.NotifySettings(userID, convID, settings); err != nil {
+		log.Printf("Error delivering settings: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+// notify forwards a membership change to connected clients. The change is already
+// saved, so a failed notification is logged rather than reported to the caller.
+func (controller *ConversationController) notify(conversation *model.Conversation, action string, actorID primitive.ObjectID, userIDs []primitive.ObjectID) {
+	if err := controller.notifier.NotifyMembership(conversation, action, actorID, userIDs); err != nil {
+		log.Printf("Error delivering membership event: %v", err)
+	}
+}
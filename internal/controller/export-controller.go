@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"simple-chat-app/internal/export"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+)
+
+type ExportController struct {
+	conversationService *service.ConversationService
+	exportService       *service.ExportService
+}
+
+func NewExportController(conversationService *service.ConversationService, exportService *service.ExportService) *ExportController {
+	return &ExportController{
+		conversationService: conversationService,
+		exportService:       exportService,
+	}
+}
+
+// ExportHandler streams the transcript of a conversation as a file download.
+// format is jsonl (default), csv, html or text; tz is a time zone such as Europe/Paris;
+// from and to are dates or RFC 3339 times bounding the messages exported.
+func (controller *ExportController) ExportHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	opts := service.ExportOptions{Format: c.DefaultQuery("format", export.FormatJSONL)}
+	if !export.ValidFormat(opts.Format) {
+		c.Error(utils.NewBadRequestError("format must be jsonl, csv, html or text"))
+		return
+	}
+	if opts.Location, err = export.ParseLocation(c.Query("tz")); err != nil {
+		c.Error(utils.NewBadRequestError(err.Error()))
+		return
+	}
+	if opts.From, opts.To, err = export.ParseRange(c.Query("from"), c.Query("to"), opts.Location); err != nil {
+		c.Error(utils.NewBadRequestError(err.Error()))
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// A long history can take longer to stream than the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error lifting the write deadline of an export: %v", err)
+	}
+
+	filename := fmt.Sprintf("conversation-%s.%s", convID.Hex(), export.FileExtension(opts.Format))
+	c.Header("Content-Type", export.ContentType(opts.Format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the transcript short
+	if err := controller.exportService.Export(c.Request.Context(), c.Writer, conversation, opts); err != nil {
+		log.Printf("Error exporting conversation %s: %v", convID.Hex(), err)
+	}
+}
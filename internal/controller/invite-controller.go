@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+	"simple-chat-app/internal/websocket"
+)
+
+type InviteController struct {
+	inviteService *service.InviteService
+	notifier      ConversationNotifier
+}
+
+func NewInviteController(inviteService *service.InviteService, notifier ConversationNotifier) *InviteController {
+	return &InviteController{
+		inviteService: inviteService,
+		notifier:      notifier,
+	}
+}
+
+type createInviteRequest struct {
+	ExpiresIn string `json:"expiresIn"`
+	MaxUses   int    `json:"maxUses"`
+	Role      string `json:"role"`
+}
+
+// CreateInviteHandler creates an invite link to a group. expiresIn is a duration
+// such as "24h"; leave it out for an invite that never expires.
+func (controller *InviteController) CreateInviteHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req createInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	opts := service.InviteOptions{MaxUses: req.MaxUses, Role: req.Role}
+	if req.ExpiresIn != "" {
+		if opts.ExpiresIn, err = time.ParseDuration(req.ExpiresIn); err != nil {
+			c.Error(utils.NewBadRequestError("expiresIn must be a duration such as 24h"))
+			return
+		}
+	}
+
+	invite, err := controller.inviteService.Create(c.Request.Context(), convID, userID, opts)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"invite": invite})
+}
+
+// ListInvitesHandler returns the invites of a group that can still be used.
+func (controller *InviteController) ListInvitesHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	invites, err := controller.inviteService.List(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// RevokeInviteHandler makes an invite of a group unusable.
+func (controller *InviteController) RevokeInviteHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	inviteID, err := paramObjectID(c, "inviteId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.inviteService.Revoke(c.Request.Context(), convID, inviteID, userID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PreviewInviteHandler describes the group an invite leads to.
+func (controller *InviteController) PreviewInviteHandler(c *gin.Context) {
+	preview, err := controller.inviteService.Preview(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite": preview})
+}
+
+// JoinInviteHandler adds the authenticated user to the group of an invite and tells
+// its members.
+func (controller *InviteController) JoinInviteHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, joined, err := controller.inviteService.Join(c.Request.Context(), c.Param("token"), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if joined {
+		if err := controller.notifier.NotifyMembership(conversation, websocket.MembershipJoined, userID, []primitive.ObjectID{userID}); err != nil {
+			log.Printf("Error delivering membership event: %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
@@ -0,0 +1,448 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/export"
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+)
+
+// MessageNotifier tells connected clients about message changes made over REST.
+type MessageNotifier interface {
+	NotifyMessage(conversation *model.Conversation, message *model.Message) error
+	NotifyMessageEdited(conversation *model.Conversation, message *model.Message, mentioned []primitive.ObjectID) error
+	NotifyMessageDeleted(conversation *model.Conversation, message *model.Message, userID primitive.ObjectID, forEveryone bool) error
+	NotifyReaction(conversation *model.Conversation, change *service.ReactionChange, added bool) error
+}
+
+type MessageController struct {
+	conversationService *service.ConversationService
+	messageService      *service.MessageService
+	notifier            MessageNotifier
+}
+
+func NewMessageController(conversationService *service.ConversationService, messageService *service.MessageService, notifier MessageNotifier) *MessageController {
+	return &MessageController{
+		conversationService: conversationService,
+		messageService:      messageService,
+		notifier:            notifier,
+	}
+}
+
+type messageRequest struct {
+	Message string `json:"message"`
+}
+
+// ListMessagesHandler returns a page of a conversation's history, oldest message first.
+// before, after and around are message sequence numbers to page from; at jumps to a
+// date or RFC 3339 time, read in the tz time zone (UTC by default). Without any of
+// them the page holds the latest messages. limit sets the page size.
+func (controller *MessageController) ListMessagesHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	query, err := historyQuery(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page, err := controller.messageService.History(c.Request.Context(), conversation, userID, query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ThreadHandler returns a page of the replies in the thread of a message, oldest
+// first, with the thread root and whether the user follows it. It is paged like
+// ListMessagesHandler.
+func (controller *MessageController) ThreadHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	query, err := historyQuery(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page, err := controller.messageService.Thread(c.Request.Context(), conversation, messageID, userID, query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ReplyHandler posts a reply in the thread of a message as the authenticated user.
+func (controller *MessageController) ReplyHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req messageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	message, err := controller.messageService.Create(model.Message{
+		ConversationId: conversation.ID,
+		SenderId:       userID,
+		Message:        req.Message,
+		ParentId:       &messageID,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.notifier.NotifyMessage(conversation, message); err != nil {
+		log.Printf("Error delivering message %s: %v", message.ID.Hex(), err)
+	}
+	c.JSON(http.StatusCreated, message)
+}
+
+// FollowThreadHandler makes the authenticated user follow the thread of a message,
+// to be alerted of new replies.
+func (controller *MessageController) FollowThreadHandler(c *gin.Context) {
+	controller.followThread(c, true)
+}
+
+// UnfollowThreadHandler makes the authenticated user stop following the thread of a message.
+func (controller *MessageController) UnfollowThreadHandler(c *gin.Context) {
+	controller.followThread(c, false)
+}
+
+func (controller *MessageController) followThread(c *gin.Context, follow bool) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.messageService.FollowThread(c.Request.Context(), conversation, messageID, userID, follow); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// EditMessageHandler replaces the text of one of the authenticated user's messages.
+func (controller *MessageController) EditMessageHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req messageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(utils.NewBadRequestError("Invalid request payload"))
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	message, mentioned, err := controller.messageService.Edit(c.Request.Context(), conversation, messageID, userID, req.Message)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.notifier.NotifyMessageEdited(conversation, message, mentioned); err != nil {
+		log.Printf("Error notifying the edit of message %s: %v", message.ID.Hex(), err)
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+// DeleteMessageHandler hides a message from the authenticated user, or with
+// forEveryone=true deletes it for every participant, which the sender and the owners
+// and admins of the conversation can do. The message is returned as the user now sees it.
+func (controller *MessageController) DeleteMessageHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	forEveryone := c.Query("forEveryone") == "true"
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var message *model.Message
+	if forEveryone {
+		message, err = controller.messageService.DeleteForEveryone(c.Request.Context(), conversation, messageID, userID)
+	} else {
+		message, err = controller.messageService.DeleteForMe(c.Request.Context(), conversation, messageID, userID)
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := controller.notifier.NotifyMessageDeleted(conversation, message, userID, forEveryone); err != nil {
+		log.Printf("Error notifying the deletion of message %s: %v", message.ID.Hex(), err)
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+// AddReactionHandler reacts to a message with the emoji in the path on behalf of the
+// authenticated user.
+func (controller *MessageController) AddReactionHandler(c *gin.Context) {
+	controller.react(c, true)
+}
+
+// RemoveReactionHandler withdraws the authenticated user's reaction with the emoji
+// in the path.
+func (controller *MessageController) RemoveReactionHandler(c *gin.Context) {
+	controller.react(c, false)
+}
+
+func (controller *MessageController) react(c *gin.Context, add bool) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	emoji := c.Param("emoji")
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var change *service.ReactionChange
+	if add {
+		change, err = controller.messageService.AddReaction(c.Request.Context(), conversation, messageID, userID, emoji)
+	} else {
+		change, err = controller.messageService.RemoveReaction(c.Request.Context(), conversation, messageID, userID, emoji)
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if change.Changed {
+		if err := controller.notifier.NotifyReaction(conversation, change, add); err != nil {
+			log.Printf("Error notifying a reaction to message %s: %v", messageID.Hex(), err)
+		}
+	}
+	c.JSON(http.StatusOK, change)
+}
+
+// MentionsHandler returns the messages mentioning the authenticated user, newest
+// first. Pass the nextCursor of a page as the cursor query parameter to get the
+// next one; limit sets the page size.
+func (controller *MessageController) MentionsHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var limit int
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			c.Error(utils.NewBadRequestError("limit must be a positive number"))
+			return
+		}
+	}
+
+	page, err := controller.messageService.Mentions(c.Request.Context(), userID, c.Query("cursor"), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// EditHistoryHandler returns the prior versions of a message. Only owners and
+// admins of the conversation can see them.
+func (controller *MessageController) EditHistoryHandler(c *gin.Context) {
+	userID, err := currentUserID(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	convID, err := paramObjectID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	messageID, err := paramObjectID(c, "messageId")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	conversation, err := controller.conversationService.Authorize(c.Request.Context(), convID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	history, err := controller.messageService.EditHistory(c.Request.Context(), conversation, messageID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// historyQuery reads the paging parameters of a history or thread request.
+func historyQuery(c *gin.Context) (service.HistoryQuery, error) {
+	var query service.HistoryQuery
+	var err error
+	if query.Before, err = querySeq(c, "before"); err != nil {
+		return query, err
+	}
+	if query.After, err = querySeq(c, "after"); err != nil {
+		return query, err
+	}
+	if query.Around, err = querySeq(c, "around"); err != nil {
+		return query, err
+	}
+	if raw := c.Query("at"); raw != "" {
+		loc, err := export.ParseLocation(c.Query("tz"))
+		if err != nil {
+			return query, utils.NewBadRequestError(err.Error())
+		}
+		if query.At, err = service.ParseHistoryTime(raw, loc); err != nil {
+			return query, utils.NewBadRequestError("invalid at: " + err.Error())
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil || query.Limit <= 0 {
+			return query, utils.NewBadRequestError("limit must be a positive number")
+		}
+	}
+	return query, nil
+}
+
+// querySeq reads an optional message sequence number from the query string.
+func querySeq(c *gin.Context, name string) (int64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, utils.NewBadRequestError(name + " must be a positive message sequence number")
+	}
+	return seq, nil
+}
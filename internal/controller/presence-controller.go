@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+)
+
+// maxPresenceQuery caps how many users can be looked up in one presence request.
+const maxPresenceQuery = 100
+
+type PresenceController struct {
+	presenceService *service.PresenceService
+}
+
+func NewPresenceController(presenceService *service.PresenceService) *PresenceController {
+	return &PresenceController{
+		presenceService: presenceService,
+	}
+}
+
+// GetPresenceHandler returns the online state and last seen time of a list of users,
+// given as a comma separated userIds query parameter.
+func (controller *PresenceController) GetPresenceHandler(c *gin.Context) {
+	var userIDs []primitive.ObjectID
+	for _, raw := range strings.Split(c.Query("userIds"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.Error(utils.NewBadRequestError("userIds must be a comma separated list of user IDs"))
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	if len(userIDs) == 0 {
+		c.Error(utils.NewBadRequestError("userIds is required"))
+		return
+	}
+	if len(userIDs) > maxPresenceQuery {
+		c.Error(utils.NewBadRequestError("too many userIds"))
+		return
+	}
+
+	presences, err := controller.presenceService.Get(c.Request.Context(), userIDs)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": presences})
+}
@@ -18,20 +18,34 @@ func NewUserController() *UserController {
 	}
 }
 
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Image    string `json:"image"`
+}
+
 func (controller *UserController) CreateUserHttp(c *gin.Context) {
-	var userInput model.User
-	if err := c.ShouldBindJSON(&userInput); err != nil {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
 
+	userInput := model.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: req.Password,
+		Image:    req.Image,
+	}
+
 	createdUser, err := controller.userService.Create(userInput)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, createdUser)
+	c.JSON(http.StatusCreated, createdUser.Profile())
 }
 
 type VerifyEmailRequest struct {
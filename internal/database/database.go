@@ -3,7 +3,6 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
@@ -11,17 +10,6 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var globalClient *mongo.Client
-
-// init initializes the MongoDB client and assigns it to the globalClient variable.
-func init() {
-	client, err := DBinstance()
-	if err != nil {
-		log.Fatalf("Failed to initialize MongoDB client: %v", err)
-	}
-	globalClient = client
-}
-
 // DBinstance creates a new MongoDB client instance and connects to the database.
 // It returns the client instance or an error if the connection fails.
 func DBinstance() (*mongo.Client, error) {
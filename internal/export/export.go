@@ -0,0 +1,149 @@
+// Package export writes conversation transcripts in the supported formats. Writers
+// take one entry at a time so that transcripts can be streamed.
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	// Embed the time zone database so tz filters work on hosts without one
+	_ "time/tzdata"
+)
+
+// Transcript formats.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+	FormatHTML  = "html"
+	FormatText  = "text"
+)
+
+// dateLayout is how a date without a time is written in a range filter.
+const dateLayout = "2006-01-02"
+
+// Entry is one message of a transcript.
+type Entry struct {
+	Seq        int64     `json:"seq"`
+	At         time.Time `json:"at"`
+	SenderId   string    `json:"senderId"`
+	SenderName string    `json:"senderName"`
+	Message    string    `json:"message"`
+}
+
+// Header describes the transcript as a whole. Times are written in Location.
+type Header struct {
+	ConversationId string
+	Title          string
+	Location       *time.Location
+	From, To       time.Time
+}
+
+// Writer writes the entries of a transcript in order. Close finishes the transcript
+// and must be called once all entries are written; it does not close the underlying writer.
+type Writer interface {
+	WriteEntry(entry Entry) error
+	Close() error
+}
+
+// NewWriter starts a transcript in the given format on w.
+func NewWriter(format string, w io.Writer, header Header) (Writer, error) {
+	if header.Location == nil {
+		header.Location = time.UTC
+	}
+
+	switch format {
+	case FormatJSONL:
+		return newJSONLWriter(w, header), nil
+	case FormatCSV:
+		return newCSVWriter(w, header)
+	case FormatHTML:
+		return newHTMLWriter(w, header)
+	case FormatText:
+		return newTextWriter(w, header)
+	default:
+		return nil, fmt.Errorf("unknown format %q, use jsonl, csv, html or text", format)
+	}
+}
+
+// ContentType returns the MIME type of a transcript format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// FileExtension returns the usual file extension of a transcript format.
+func FileExtension(format string) string {
+	if format == FormatText {
+		return "txt"
+	}
+	return format
+}
+
+// ValidFormat reports whether the format is supported.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatJSONL, FormatCSV, FormatHTML, FormatText:
+		return true
+	}
+	return false
+}
+
+// ParseLocation parses a time zone name such as Europe/Paris; empty means UTC.
+func ParseLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// ParseRange parses the optional bounds of a date range, each either an RFC 3339
+// time or a date such as 2024-01-31 in the given location. A date as the upper
+// bound includes that whole day. Zero times are returned for empty bounds.
+func ParseRange(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+
+	if from != "" {
+		if start, _, err = parseBound(from, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if to != "" {
+		var dateOnly bool
+		if end, dateOnly, err = parseBound(to, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	return start, end, nil
+}
+
+// parseBound parses one bound of a date range and reports whether it was a date only.
+func parseBound(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(dateLayout, value, loc); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither a date (2006-01-02) nor an RFC 3339 time", value)
+	}
+	return t, false, nil
+}
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"time"
+)
+
+// timeLayout is how times are written in CSV and text transcripts.
+const timeLayout = "2006-01-02 15:04:05 MST"
+
+// jsonlWriter writes one JSON object per message.
+type jsonlWriter struct {
+	buf      *bufio.Writer
+	encoder  *json.Encoder
+	location *time.Location
+}
+
+func newJSONLWriter(w io.Writer, header Header) *jsonlWriter {
+	buf := bufio.NewWriter(w)
+	return &jsonlWriter{buf: buf, encoder: json.NewEncoder(buf), location: header.Location}
+}
+
+func (jw *jsonlWriter) WriteEntry(entry Entry) error {
+	entry.At = entry.At.In(jw.location)
+	return jw.encoder.Encode(entry)
+}
+
+func (jw *jsonlWriter) Close() error {
+	return jw.buf.Flush()
+}
+
+// csvWriter writes a header row and one row per message.
+type csvWriter struct {
+	csv      *csv.Writer
+	location *time.Location
+}
+
+func newCSVWriter(w io.Writer, header Header) (*csvWriter, error) {
+	cw := &csvWriter{csv: csv.NewWriter(w), location: header.Location}
+	if err := cw.csv.Write([]string{"seq", "time", "senderId", "sender", "message"}); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) WriteEntry(entry Entry) error {
+	return cw.csv.Write([]string{
+		strconv.FormatInt(entry.Seq, 10),
+		entry.At.In(cw.location).Format(timeLayout),
+		entry.SenderId,
+		entry.SenderName,
+		entry.Message,
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	cw.csv.Flush()
+	return cw.csv.Error()
+}
+
+// textWriter writes one line per message, prefixed with its time and sender.
+type textWriter struct {
+	buf      *bufio.Writer
+	location *time.Location
+}
+
+func newTextWriter(w io.Writer, header Header) (*textWriter, error) {
+	tw := &textWriter{buf: bufio.NewWriter(w), location: header.Location}
+	if _, err := fmt.Fprintf(tw.buf, "%s\n%s\n\n", header.Title, describeRange(header)); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+func (tw *textWriter) WriteEntry(entry Entry) error {
+	_, err := fmt.Fprintf(tw.buf, "[%s] %s: %s\n", entry.At.In(tw.location).Format(timeLayout), entry.SenderName, entry.Message)
+	return err
+}
+
+func (tw *textWriter) Close() error {
+	return tw.buf.Flush()
+}
+
+// htmlWriter writes a self-contained HTML page, with the styles inlined.
+type htmlWriter struct {
+	buf      *bufio.Writer
+	location *time.Location
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+header p { color: #59636e; }
+.message { padding: .5rem 0; border-bottom: 1px solid #d1d9e0; }
+.sender { font-weight: 600; }
+time { color: #59636e; font-size: .85em; margin-left: .5rem; }
+.body { white-space: pre-wrap; margin-top: .25rem; }
+</style>
+</head>
+<body>
+<header><h1>%s</h1><p>%s</p></header>
+<main>
+`
+
+const htmlFoot = `</main>
+</body>
+</html>
+`
+
+func newHTMLWriter(w io.Writer, header Header) (*htmlWriter, error) {
+	hw := &htmlWriter{buf: bufio.NewWriter(w), location: header.Location}
+	title := html.EscapeString(header.Title)
+	if _, err := fmt.Fprintf(hw.buf, htmlHead, title, title, html.EscapeString(describeRange(header))); err != nil {
+		return nil, err
+	}
+	return hw, nil
+}
+
+func (hw *htmlWriter) WriteEntry(entry Entry) error {
+	at := entry.At.In(hw.location)
+	_, err := fmt.Fprintf(hw.buf,
+		"<div class=\"message\" id=\"m%d\"><span class=\"sender\">%s</span><time datetime=\"%s\">%s</time><div class=\"body\">%s</div></div>\n",
+		entry.Seq,
+		html.EscapeString(entry.SenderName),
+		at.Format(time.RFC3339),
+		at.Format(timeLayout),
+		html.EscapeString(entry.Message),
+	)
+	return err
+}
+
+func (hw *htmlWriter) Close() error {
+	if _, err := hw.buf.WriteString(htmlFoot); err != nil {
+		return err
+	}
+	return hw.buf.Flush()
+}
+
+// describeRange says which messages a transcript holds, for its heading.
+func describeRange(header Header) string {
+	format := func(t time.Time) string { return t.In(header.Location).Format(timeLayout) }
+
+	switch {
+	case !header.From.IsZero() && !header.To.IsZero():
+		return fmt.Sprintf("Messages from %s until %s", format(header.From), format(header.To))
+	case !header.From.IsZero():
+		return fmt.Sprintf("Messages from %s", format(header.From))
+	case !header.To.IsZero():
+		return fmt.Sprintf("Messages until %s", format(header.To))
+	default:
+		return fmt.Sprintf("All messages, times in %s", header.Location)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+var errInvalidToken = errors.New("Invalid token")
+
 // ErrorResponse is a helper function for sending a JSON error response
 func ErrorResponse(c *gin.Context, statusCode int, message, errorCode, serviceName string) {
 	c.JSON(statusCode, gin.H{
@@ -35,18 +37,7 @@ func VerifyToken(serviceName string, accessTokenSecret string) gin.HandlerFunc {
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 
 		// Parse and verify the JWT token
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(accessTokenSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			ErrorResponse(c, http.StatusUnauthorized, "Invalid token", "VALIDATION_ERROR", serviceName)
-			return
-		}
-
-		// Extract user ID from the token claims
-		userID, err := extractUserIDFromClaims(claims)
+		userID, err := ParseAccessToken(tokenStr, accessTokenSecret)
 		if err != nil {
 			ErrorResponse(c, http.StatusUnauthorized, err.Error(), "VALIDATION_ERROR", serviceName)
 			return
@@ -60,6 +51,22 @@ func VerifyToken(serviceName string, accessTokenSecret string) gin.HandlerFunc {
 	}
 }
 
+// ParseAccessToken verifies a raw JWT with the given secret and returns the user ID it was issued for.
+// It is shared by the HTTP middleware and the WebSocket handshake so both apply the same rules.
+func ParseAccessToken(tokenStr string, accessTokenSecret string) (string, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(accessTokenSecret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return "", errInvalidToken
+	}
+
+	// Extract user ID from the token claims
+	return extractUserIDFromClaims(claims)
+}
+
 // extractUserIDFromClaims extracts the user ID from JWT claims with proper type assertion
 func extractUserIDFromClaims(claims jwt.MapClaims) (string, error) {
 	userID, ok := claims["user_id"].(string)
@@ -1,9 +1,12 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
 	"os"
 	"simple-chat-app/internal/utils"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -25,6 +28,7 @@ func ErrorHandlerMiddleware(c *gin.Context) {
 	statusCode := http.StatusInternalServerError
 	msg := "Internal Server Error"
 	errorCode := "INTERNAL_SERVER_ERROR"
+	var retryAt *time.Time
 
 	// Handle different error types
 	switch {
@@ -46,6 +50,7 @@ func ErrorHandlerMiddleware(c *gin.Context) {
 		statusCode = customErr.HTTPStatusCode
 		msg = customErr.Message
 		errorCode = "CUSTOM_ERROR"
+		retryAt = customErr.RetryAt
 
 	case isMongoError(err):
 		// MongoDB-related errors
@@ -57,14 +62,21 @@ func ErrorHandlerMiddleware(c *gin.Context) {
 		logError(c, err) // Optional: log the error for further analysis
 	}
 
-	// Send the error response in JSON format
-	c.JSON(statusCode, gin.H{
+	response := gin.H{
 		"success":        false,
 		"message":        msg,
 		"httpStatusCode": statusCode,
 		"error":          errorCode,
 		"service":        serviceName,
-	})
+	}
+	if retryAt != nil {
+		response["retryAt"] = retryAt
+		// A retryAt that is already due still asks the client to wait a second
+		c.Header("Retry-After", strconv.Itoa(max(1, int(math.Ceil(time.Until(*retryAt).Seconds())))))
+	}
+
+	// Send the error response in JSON format
+	c.JSON(statusCode, response)
 }
 
 // Helper function to identify a custom error
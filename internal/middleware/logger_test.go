@@ -0,0 +1,21 @@
+package middleware
+
+import "testing"
+
+func TestRedactPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/ws", "/ws"},
+		{"/ws?access_token=secret.jwt.value", "/ws?access_token=REDACTED"},
+		{"/ws?v=2&access_token=secret", "/ws?access_token=REDACTED&v=2"},
+		{"/v1/mentions?limit=20", "/v1/mentions?limit=20"},
+		{"/ws?access_token=%zz", "/ws?[unparsable query]"},
+	}
+	for _, test := range tests {
+		if got := redactPath(test.path); got != test.want {
+			t.Errorf("redactPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
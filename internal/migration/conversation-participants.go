@@ -0,0 +1,46 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"simple-chat-app/internal/model"
+)
+
+func init() {
+	register(Migration{
+		Name:        "conversation-participants",
+		Description: "turn senderId/receiverId conversations into direct conversations with two participants",
+		Run:         migrateConversationParticipants,
+	})
+}
+
+// migrateConversationParticipants rewrites every conversation that still has a
+// senderId and receiverId into a direct conversation whose participants joined
+// when it was created.
+func migrateConversationParticipants(ctx context.Context, db *mongo.Database) (string, error) {
+	filter := bson.M{
+		"participants": bson.M{"$exists": false},
+		"senderId":     bson.M{"$exists": true},
+	}
+
+	participant := func(field string) bson.M {
+		return bson.M{"userId": field, "role": model.RoleMember, "joinedAt": "$createdAt"}
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"type":         model.ConversationTypeDirect,
+			"participants": bson.A{participant("$senderId"), participant("$receiverId")},
+		}}},
+		{{Key: "$unset", Value: bson.A{"senderId", "receiverId"}}},
+	}
+
+	result, err := db.Collection("conversation").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("migrated %d conversations", result.ModifiedCount), nil
+}
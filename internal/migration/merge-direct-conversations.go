@@ -0,0 +1,171 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/model"
+)
+
+func init() {
+	register(Migration{
+		Name:        "merge-direct-conversations",
+		Description: "give direct conversations their pair key and merge duplicates of the same pair",
+		Run:         mergeDirectConversations,
+	})
+}
+
+// mergeDirectConversations sets the directKey of every direct conversation that lacks
+// one. When a pair of users has several direct conversations, the oldest survives:
+// the messages of the others are moved into it and renumbered by creation time,
+// read pointers are merged, and the duplicates are deleted. Each pair is merged in a
+// transaction, so a failure leaves it as it was and the migration can be run again.
+// Run it with the API stopped, after conversation-participants.
+func mergeDirectConversations(ctx context.Context, db *mongo.Database) (string, error) {
+	conversations := db.Collection("conversation")
+	messages := db.Collection("message")
+
+	cursor, err := conversations.Find(ctx, bson.M{"type": model.ConversationTypeDirect})
+	if err != nil {
+		return "", err
+	}
+	var direct []model.Conversation
+	if err := cursor.All(ctx, &direct); err != nil {
+		return "", err
+	}
+
+	pairs := make(map[string][]model.Conversation)
+	for _, conversation := range direct {
+		if len(conversation.Participants) != 2 {
+			continue
+		}
+		key := model.DirectKey(conversation.Participants[0].UserId, conversation.Participants[1].UserId)
+		pairs[key] = append(pairs[key], conversation)
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.EndSession(ctx)
+
+	keyed, merged := 0, 0
+	for key, group := range pairs {
+		if len(group) == 1 {
+			if group[0].DirectKey == key {
+				continue
+			}
+			if _, err := conversations.UpdateByID(ctx, group[0].ID, bson.M{"$set": bson.M{"directKey": key}}); err != nil {
+				return "", err
+			}
+			keyed++
+			continue
+		}
+
+		_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, mergeGroup(sc, conversations, messages, key, group)
+		})
+		if err != nil {
+			return "", fmt.Errorf("merging %s: %w", key, err)
+		}
+		merged += len(group) - 1
+	}
+
+	return fmt.Sprintf("keyed %d conversations, merged %d duplicates", keyed, merged), nil
+}
+
+// mergeGroup folds the direct conversations of one pair of users into the oldest.
+// It runs in a transaction: ctx is its session context.
+func mergeGroup(ctx context.Context, conversations, messages *mongo.Collection, key string, group []model.Conversation) error {
+	sort.Slice(group, func(i, j int) bool { return group[i].CreatedAt.Before(group[j].CreatedAt) })
+	survivor := group[0]
+
+	ids := make([]primitive.ObjectID, 0, len(group))
+	for _, conversation := range group {
+		ids = append(ids, conversation.ID)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := messages.Find(ctx, bson.M{"conversationId": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return err
+	}
+	var history []model.Message
+	if err := cursor.All(ctx, &history); err != nil {
+		return err
+	}
+
+	// Renumber in two passes so no intermediate state breaks the unique
+	// conversationId+seq index: first to unused negative numbers, then to 1..n.
+	newSeq := make(map[primitive.ObjectID]int64, len(history))
+	for i, message := range history {
+		update := bson.M{"$set": bson.M{"conversationId": survivor.ID, "seq": -int64(i + 1)}}
+		if _, err := messages.UpdateByID(ctx, message.ID, update); err != nil {
+			return err
+		}
+		newSeq[message.ID] = int64(i + 1)
+	}
+	for i, message := range history {
+		if _, err := messages.UpdateByID(ctx, message.ID, bson.M{"$set": bson.M{"seq": int64(i + 1)}}); err != nil {
+			return err
+		}
+	}
+
+	// Each user keeps the pointer that reaches furthest into the merged history
+	lastRead := make(map[primitive.ObjectID]model.ReadPointer)
+	joinedAt := make(map[primitive.ObjectID]model.Participant)
+	updatedAt := survivor.UpdatedAt
+	for _, conversation := range group {
+		for _, pointer := range conversation.LastRead {
+			seq, ok := newSeq[pointer.MessageId]
+			if !ok {
+				continue
+			}
+			pointer.Seq = seq
+			if current, ok := lastRead[pointer.UserId]; !ok || current.Seq < seq {
+				lastRead[pointer.UserId] = pointer
+			}
+		}
+		for _, participant := range conversation.Participants {
+			if current, ok := joinedAt[participant.UserId]; !ok || participant.JoinedAt.Before(current.JoinedAt) {
+				joinedAt[participant.UserId] = participant
+			}
+		}
+		if conversation.UpdatedAt.After(updatedAt) {
+			updatedAt = conversation.UpdatedAt
+		}
+	}
+
+	participants := make([]model.Participant, 0, len(survivor.Participants))
+	for _, participant := range survivor.Participants {
+		participants = append(participants, joinedAt[participant.UserId])
+	}
+	pointers := make([]model.ReadPointer, 0, len(lastRead))
+	for _, pointer := range lastRead {
+		pointers = append(pointers, pointer)
+	}
+
+	update := bson.M{"$set": bson.M{
+		"participants": participants,
+		"lastSeq":      int64(len(history)),
+		"lastRead":     pointers,
+		"updatedAt":    updatedAt,
+	}}
+	if _, err := conversations.UpdateByID(ctx, survivor.ID, update); err != nil {
+		return err
+	}
+
+	if _, err := conversations.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids[1:]}}); err != nil {
+		return err
+	}
+
+	// Set the key last, once the duplicates that would collide with it are gone
+	_, err = conversations.UpdateByID(ctx, survivor.ID, bson.M{"$set": bson.M{"directKey": key}})
+	return err
+}
@@ -0,0 +1,123 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/model"
+)
+
+// sequenceBatchSize is the number of message updates sent in one bulk write.
+const sequenceBatchSize = 1000
+
+func init() {
+	register(Migration{
+		Name:        "message-sequence-numbers",
+		Description: "number the messages of conversations created before sequence numbers, and set their lastSeq",
+		Run:         NumberMessages,
+	})
+}
+
+// NumberMessages gives the messages of every conversation without a lastSeq, i.e.
+// created before sequence numbers, the numbers 1..n by creation time, then sets the
+// conversation's lastSeq to n and the seq of its read pointers to match. lastSeq is
+// set last, so a conversation interrupted halfway is numbered again on the next run.
+// The server runs it at startup, before building the unique conversationId+seq index
+// that the unnumbered messages would break.
+func NumberMessages(ctx context.Context, db *mongo.Database) (string, error) {
+	conversations := db.Collection("conversation")
+	messages := db.Collection("message")
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "lastRead": 1})
+	cursor, err := conversations.Find(ctx, bson.M{"lastSeq": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return "", err
+	}
+	var legacy []model.Conversation
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return "", err
+	}
+
+	numbered := int64(0)
+	for _, conversation := range legacy {
+		count, err := numberConversation(ctx, conversations, messages, conversation)
+		if err != nil {
+			return "", fmt.Errorf("numbering conversation %s: %w", conversation.ID.Hex(), err)
+		}
+		numbered += count
+	}
+
+	return fmt.Sprintf("numbered %d messages in %d conversations", numbered, len(legacy)), nil
+}
+
+// numberConversation numbers the messages of one conversation and returns how many it has.
+func numberConversation(ctx context.Context, conversations, messages *mongo.Collection, conversation model.Conversation) (int64, error) {
+	filter := bson.M{"conversationId": conversation.ID}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"_id": 1, "seq": 1})
+	cursor, err := messages.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var history []model.Message
+	if err := cursor.All(ctx, &history); err != nil {
+		return 0, err
+	}
+
+	// Renumber in two passes, like mergeGroup, so that a conversation partly
+	// numbered by an interrupted run never breaks the unique index: first below
+	// any number in use, then to 1..n.
+	base := int64(0)
+	for _, message := range history {
+		base = min(base, message.Seq)
+	}
+	newSeq := make(map[primitive.ObjectID]int64, len(history))
+	for i, message := range history {
+		newSeq[message.ID] = int64(i + 1)
+	}
+	if err := setSeqs(ctx, messages, history, func(i int) int64 { return base - int64(i+1) }); err != nil {
+		return 0, err
+	}
+	if err := setSeqs(ctx, messages, history, func(i int) int64 { return int64(i + 1) }); err != nil {
+		return 0, err
+	}
+
+	pointers := make([]model.ReadPointer, 0, len(conversation.LastRead))
+	for _, pointer := range conversation.LastRead {
+		if seq, ok := newSeq[pointer.MessageId]; ok {
+			pointer.Seq = seq
+		}
+		pointers = append(pointers, pointer)
+	}
+	set := bson.M{"lastSeq": int64(len(history))}
+	if len(pointers) > 0 {
+		set["lastRead"] = pointers
+	}
+	if _, err := conversations.UpdateByID(ctx, conversation.ID, bson.M{"$set": set}); err != nil {
+		return 0, err
+	}
+	return int64(len(history)), nil
+}
+
+// setSeqs sets the seq of each message of history to seq(i), in ordered batches.
+func setSeqs(ctx context.Context, messages *mongo.Collection, history []model.Message, seq func(i int) int64) error {
+	for start := 0; start < len(history); start += sequenceBatchSize {
+		end := min(start+sequenceBatchSize, len(history))
+		models := make([]mongo.WriteModel, 0, end-start)
+		for i := start; i < end; i++ {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": history[i].ID}).
+				SetUpdate(bson.M{"$set": bson.M{"seq": seq(i)}}))
+		}
+		if _, err := messages.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a one-off change to the stored data. Running a migration twice
+// must be harmless: it only touches documents still in the old shape.
+type Migration struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, db *mongo.Database) (string, error)
+}
+
+var migrations = map[string]Migration{}
+
+// register makes a migration available to Find and All.
+func register(m Migration) {
+	migrations[m.Name] = m
+}
+
+// Find returns the migration with the given name.
+func Find(name string) (Migration, error) {
+	m, ok := migrations[name]
+	if !ok {
+		return Migration{}, fmt.Errorf("unknown migration %q", name)
+	}
+	return m, nil
+}
+
+// All returns every known migration sorted by name.
+func All() []Migration {
+	all := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
@@ -6,10 +6,171 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Conversation types.
+const (
+	ConversationTypeDirect  = "direct"
+	ConversationTypeGroup   = "group"
+	ConversationTypeChannel = "channel"
+)
+
+// Channel visibilities. Anyone can find and join a public channel; private
+// channels are only joined through invites.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Participant roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// DirectKey identifies the direct conversation between two users, whichever of them started it.
+func DirectKey(a, b primitive.ObjectID) string {
+	if b.Hex() < a.Hex() {
+		a, b = b, a
+	}
+	return a.Hex() + ":" + b.Hex()
+}
+
 type Conversation struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	SenderId   primitive.ObjectID `bson:"senderId" json: "senderId"`
-	ReceiverId primitive.ObjectID `bson:"receiverId" json: "receiverId"`
-	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Type          string             `bson:"type" json:"type"`
+	Title         string             `bson:"title,omitempty" json:"title,omitempty"`
+	Avatar        string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	Name          string             `bson:"name,omitempty" json:"name,omitempty"`
+	Topic         string             `bson:"topic,omitempty" json:"topic,omitempty"`
+	Visibility    string             `bson:"visibility,omitempty" json:"visibility,omitempty"`
+	DirectKey     string             `bson:"directKey,omitempty" json:"-"`
+	Participants  []Participant      `bson:"participants" json:"participants"`
+	Moderation    Moderation         `bson:"moderation,omitempty" json:"moderation"`
+	LastSeq       int64              `bson:"lastSeq" json:"lastSeq"`
+	LastRead      []ReadPointer      `bson:"lastRead,omitempty" json:"lastRead,omitempty"`
+	LastMessageAt time.Time          `bson:"lastMessageAt,omitempty" json:"lastMessageAt,omitempty"`
+	// LastPostedAt is when each participant last posted, keyed by user ID, for slow
+	// mode. It is kept out of Participants, which membership changes write back whole.
+	LastPostedAt map[string]time.Time `bson:"lastPostedAt,omitempty" json:"-"`
+	CreatedAt    time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Notification levels of a participant.
+const (
+	NotificationAll      = "all"
+	NotificationMentions = "mentions"
+	NotificationNone     = "none"
+)
+
+// Participant is a member of a conversation. Settings are private to the
+// participant and never sent along with the conversation.
+type Participant struct {
+	UserId   primitive.ObjectID  `bson:"userId" json:"userId"`
+	Role     string              `bson:"role" json:"role"`
+	JoinedAt time.Time           `bson:"joinedAt" json:"joinedAt"`
+	Settings ParticipantSettings `bson:"settings,omitempty" json:"-"`
+	// MutedUntil is set by a moderator to keep the participant from posting;
+	// unlike Settings.MutedUntil, it is about their messages, not their notifications.
+	MutedUntil *time.Time `bson:"mutedUntil,omitempty" json:"mutedUntil,omitempty"`
+}
+
+// Moderation are the posting rules of a group or channel, set by its owners and admins.
+// Owners and admins are exempt from them.
+type Moderation struct {
+	// SlowModeSeconds is the minimum time between two messages of a member.
+	SlowModeSeconds int `bson:"slowModeSeconds,omitempty" json:"slowModeSeconds,omitempty"`
+	// AnnouncementsOnly lets only owners and admins post.
+	AnnouncementsOnly bool `bson:"announcementsOnly,omitempty" json:"announcementsOnly,omitempty"`
+	// BroadcastMentions is who can mention @here and @all: members (the default),
+	// admins, or nobody at all when disabled, owners and admins included.
+	BroadcastMentions string `bson:"broadcastMentions,omitempty" json:"broadcastMentions,omitempty"`
+}
+
+// Who can mention @here and @all in a group or channel.
+const (
+	BroadcastMentionsMembers  = "members"
+	BroadcastMentionsAdmins   = "admins"
+	BroadcastMentionsDisabled = "disabled"
+)
+
+// ParticipantSettings are the personal settings of a participant for a conversation.
+type ParticipantSettings struct {
+	MutedUntil        *time.Time `bson:"mutedUntil,omitempty" json:"mutedUntil,omitempty"`
+	Archived          bool       `bson:"archived,omitempty" json:"archived"`
+	Pinned            bool       `bson:"pinned,omitempty" json:"pinned"`
+	PinOrder          int        `bson:"pinOrder,omitempty" json:"pinOrder"`
+	NotificationLevel string     `bson:"notificationLevel,omitempty" json:"notificationLevel"`
+}
+
+// Level returns the notification level, "all" unless set otherwise.
+func (s *ParticipantSettings) Level() string {
+	if s.NotificationLevel == "" {
+		return NotificationAll
+	}
+	return s.NotificationLevel
+}
+
+// Muted reports whether the conversation is muted at the given time.
+func (s *ParticipantSettings) Muted(at time.Time) bool {
+	return s.MutedUntil != nil && s.MutedUntil.After(at)
+}
+
+// Notifies reports whether a new message should alert the participant at the given
+// time: not while muted, and at the "mentions" level only when they are mentioned.
+func (s *ParticipantSettings) Notifies(at time.Time, mentioned bool) bool {
+	if s.Muted(at) {
+		return false
+	}
+	switch s.Level() {
+	case NotificationNone:
+		return false
+	case NotificationMentions:
+		return mentioned
+	default:
+		return true
+	}
+}
+
+// ReadPointer is the last message a participant has read in a conversation.
+type ReadPointer struct {
+	UserId    primitive.ObjectID `bson:"userId" json:"userId"`
+	MessageId primitive.ObjectID `bson:"messageId" json:"messageId"`
+	Seq       int64              `bson:"seq" json:"seq"`
+	ReadAt    time.Time          `bson:"readAt" json:"readAt"`
+}
+
+// ParticipantIDs returns the IDs of every user taking part in the conversation.
+func (c *Conversation) ParticipantIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(c.Participants))
+	for _, participant := range c.Participants {
+		ids = append(ids, participant.UserId)
+	}
+	return ids
+}
+
+// HasParticipant reports whether the given user takes part in the conversation.
+func (c *Conversation) HasParticipant(userID primitive.ObjectID) bool {
+	return c.Participant(userID) != nil
+}
+
+// Participant returns the membership of the given user, or nil if they are not a participant.
+func (c *Conversation) Participant(userID primitive.ObjectID) *Participant {
+	for i := range c.Participants {
+		if c.Participants[i].UserId == userID {
+			return &c.Participants[i]
+		}
+	}
+	return nil
+}
+
+// LastPostedBy returns when the user last posted in the conversation, or the zero time.
+func (c *Conversation) LastPostedBy(userID primitive.ObjectID) time.Time {
+	return c.LastPostedAt[userID.Hex()]
+}
+
+// IsAdmin reports whether the given user is an owner or admin of the conversation.
+func (c *Conversation) IsAdmin(userID primitive.ObjectID) bool {
+	participant := c.Participant(userID)
+	return participant != nil && (participant.Role == RoleOwner || participant.Role == RoleAdmin)
 }
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invite is a link that lets users join a group conversation on their own.
+// MaxUses of 0 means the invite can be used any number of times, and a nil
+// ExpiresAt that it never expires.
+type Invite struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Token          string             `bson:"token" json:"token"`
+	ConversationId primitive.ObjectID `bson:"conversationId" json:"conversationId"`
+	CreatedBy      primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	Role           string             `bson:"role" json:"role"`
+	MaxUses        int                `bson:"maxUses" json:"maxUses"`
+	Uses           int                `bson:"uses" json:"uses"`
+	ExpiresAt      *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Usable reports whether the invite can still be used at the given time.
+func (i *Invite) Usable(at time.Time) bool {
+	return i.RevokedAt == nil &&
+		(i.ExpiresAt == nil || i.ExpiresAt.After(at)) &&
+		(i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// InvitePreview is what a user sees of a group or channel before joining it through an invite.
+type InvitePreview struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	Type           string             `json:"type"`
+	Title          string             `json:"title"`
+	Topic          string             `json:"topic,omitempty"`
+	Avatar         string             `json:"avatar,omitempty"`
+	MemberCount    int                `json:"memberCount"`
+	Role           string             `json:"role"`
+	ExpiresAt      *time.Time         `json:"expiresAt,omitempty"`
+}
@@ -6,11 +6,160 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Message statuses, from the point of view of a recipient.
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+)
+
+// Broadcast mentions, addressing the online participants (@here) or all of them (@all).
+const (
+	MentionHere = "here"
+	MentionAll  = "all"
+)
+
 type Message struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	ConversationId primitive.ObjectID `bson:"conversationId" json:"conversationId"`
-	SenderId       primitive.ObjectID `bson:"senderId" json:"senderId"`
-	Message        string             `json:"message"`
-	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	ConversationId primitive.ObjectID   `bson:"conversationId" json:"conversationId"`
+	SenderId       primitive.ObjectID   `bson:"senderId" json:"senderId"`
+	Seq            int64                `bson:"seq" json:"seq"`
+	Message        string               `json:"message"`
+	ParentId       *primitive.ObjectID  `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	ThreadRootId   *primitive.ObjectID  `bson:"threadRootId,omitempty" json:"threadRootId,omitempty"`
+	Thread         *Thread              `bson:"thread,omitempty" json:"thread,omitempty"`
+	Receipts       []Receipt            `bson:"receipts" json:"receipts"`
+	Reactions      []Reaction           `bson:"reactions,omitempty" json:"reactions,omitempty"`
+	Mentions       []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	Broadcast      string               `bson:"broadcast,omitempty" json:"broadcast,omitempty"`
+	HereIds        []primitive.ObjectID `bson:"hereIds,omitempty" json:"-"`
+	EditedAt       *time.Time           `bson:"editedAt,omitempty" json:"editedAt,omitempty"`
+	Revisions      []Revision           `bson:"revisions,omitempty" json:"-"`
+	HiddenFor      []primitive.ObjectID `bson:"hiddenFor,omitempty" json:"-"`
+	DeletedAt      *time.Time           `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy      *primitive.ObjectID  `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	PurgedAt       *time.Time           `bson:"purgedAt,omitempty" json:"-"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Thread summarizes the replies to a thread root, the message a thread started from.
+// Participants are the root's sender and everyone who replied; followers are
+// alerted of new replies.
+type Thread struct {
+	ReplyCount   int64                `bson:"replyCount" json:"replyCount"`
+	LastReplyAt  *time.Time           `bson:"lastReplyAt,omitempty" json:"lastReplyAt,omitempty"`
+	Participants []primitive.ObjectID `bson:"participants" json:"participants"`
+	Followers    []primitive.ObjectID `bson:"followers" json:"-"`
+}
+
+// Follows reports whether the user follows the thread.
+func (t *Thread) Follows(userID primitive.ObjectID) bool {
+	if t == nil {
+		return false
+	}
+	for _, id := range t.Followers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Reaction is an emoji participants reacted to a message with, and who did.
+// Count is the number of users, kept alongside them so it can be read on its own.
+type Reaction struct {
+	Emoji   string               `bson:"emoji" json:"emoji"`
+	Count   int                  `bson:"count" json:"count"`
+	UserIds []primitive.ObjectID `bson:"userIds" json:"userIds"`
+}
+
+// Reaction returns the reaction of the message with the emoji, or nil if there is none.
+func (m *Message) Reaction(emoji string) *Reaction {
+	for i := range m.Reactions {
+		if m.Reactions[i].Emoji == emoji {
+			return &m.Reactions[i]
+		}
+	}
+	return nil
+}
+
+// Revision is a prior version of an edited message, with the time it was written.
+type Revision struct {
+	Message   string    `bson:"message" json:"message"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Receipt tracks how far a message got with one of its recipients.
+type Receipt struct {
+	UserId      primitive.ObjectID `bson:"userId" json:"userId"`
+	Status      string             `bson:"status" json:"status"`
+	DeliveredAt time.Time          `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+	ReadAt      time.Time          `bson:"readAt,omitempty" json:"readAt,omitempty"`
+}
+
+// MentionsUser reports whether the user is mentioned by name in the message.
+func (m *Message) MentionsUser(userID primitive.ObjectID) bool {
+	for _, id := range m.Mentions {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// BroadcastsTo reports whether the message addresses the user with @all, or with
+// @here while they were online, i.e. among the HereIds recorded when it was sent.
+func (m *Message) BroadcastsTo(userID primitive.ObjectID) bool {
+	switch m.Broadcast {
+	case MentionAll:
+		return true
+	case MentionHere:
+		for _, id := range m.HereIds {
+			if id == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Deleted reports whether the message was deleted for everyone.
+func (m *Message) Deleted() bool {
+	return m.DeletedAt != nil
+}
+
+// HiddenFrom reports whether the user deleted the message for themselves.
+func (m *Message) HiddenFrom(userID primitive.ObjectID) bool {
+	for _, id := range m.HiddenFor {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact blanks the text of a message deleted for everyone, leaving a tombstone
+// that keeps its place and sequence number in the conversation.
+func (m *Message) Redact() {
+	if m.Deleted() {
+		m.Message = ""
+		m.Revisions = nil
+		m.Reactions = nil
+	}
+}
+
+// Status returns the overall status of the message: the least advanced status
+// among its recipients, so "read" means every recipient has read it.
+func (m *Message) Status() string {
+	status := MessageStatusRead
+	for _, receipt := range m.Receipts {
+		switch receipt.Status {
+		case MessageStatusSent:
+			return MessageStatusSent
+		case MessageStatusDelivered:
+			status = MessageStatusDelivered
+		}
+	}
+	return status
 }
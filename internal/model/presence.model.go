@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Presence is the online state of a user as seen by other users.
+type Presence struct {
+	UserId     primitive.ObjectID `json:"userId"`
+	Online     bool               `json:"online"`
+	LastSeenAt *time.Time         `json:"lastSeenAt,omitempty"`
+}
@@ -5,15 +5,41 @@ import (
 	"time"
 )
 
+// User is the stored user document. Its credentials are never serialized to JSON;
+// send a UserProfile to clients instead.
 type User struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Username      string             `bson:"username" json:"username"`
-	Password      string             `bson:"password" json:"password"`
+	Password      string             `bson:"password" json:"-"`
 	Email         string             `bson:"email" json:"email"`
 	VerifiedEmail bool               `bson:"verifiedEmail" json:"verifiedEmail"`
-	OtpToken      string             `bson:"otpToken,omitempty" json:"otpToken,omitempty"`
-	ExpiredAt     time.Time          `bson:"expiredAt,omitempty" json:"expiredAt,omitempty"`
+	OtpToken      string             `bson:"otpToken,omitempty" json:"-"`
+	ExpiredAt     time.Time          `bson:"expiredAt,omitempty" json:"-"`
 	Image         string             `bson:"image" json:"image"`
+	LastSeenAt    time.Time          `bson:"lastSeenAt,omitempty" json:"lastSeenAt,omitempty"`
 	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt     time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
+
+// UserProfile is the public view of a user, safe to send to any client.
+type UserProfile struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Username   string             `bson:"username" json:"username"`
+	Image      string             `bson:"image" json:"image"`
+	LastSeenAt time.Time          `bson:"lastSeenAt,omitempty" json:"lastSeenAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// UserProfileProjection selects the UserProfile fields of a user document.
+var UserProfileProjection = map[string]int{"username": 1, "image": 1, "lastSeenAt": 1, "createdAt": 1}
+
+// Profile returns the public view of the user.
+func (u *User) Profile() UserProfile {
+	return UserProfile{
+		ID:         u.ID,
+		Username:   u.Username,
+		Image:      u.Image,
+		LastSeenAt: u.LastSeenAt,
+		CreatedAt:  u.CreatedAt,
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/websocket"
+)
+
+// redisPrefix namespaces the Redis channels and keys of the app.
+const redisPrefix = "chat:"
+
+// newRedisClient connects to the Redis server at REDIS_URL, shared by the replicas.
+// Returns nil when REDIS_URL is not set, for a single replica.
+func newRedisClient() (*redis.Client, error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return nil, nil
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to ping Redis: %w", err)
+	}
+
+	fmt.Println("Connected to Redis")
+	return client, nil
+}
+
+// newBroker picks the gateway broker: Redis pub/sub when there is a Redis client,
+// so several replicas can share deliveries, or an in-process broker otherwise.
+func newBroker(client *redis.Client) websocket.Broker {
+	if client == nil {
+		return websocket.NewMemoryBroker()
+	}
+	return websocket.NewRedisBroker(client, redisPrefix)
+}
+
+// newPresenceStore picks where connections are counted: in Redis when there is a
+// Redis client, so a user is online whichever replica they are connected to, or
+// in process otherwise.
+func newPresenceStore(client *redis.Client) service.PresenceStore {
+	if client == nil {
+		return service.NewMemoryPresenceStore()
+	}
+	return service.NewRedisPresenceStore(client, redisPrefix, service.PresenceTTL)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// defaultMessageRetention is how long the text of a message deleted for everyone is
+// kept when MESSAGE_RETENTION is not set.
+const defaultMessageRetention = 30 * 24 * time.Hour
+
+// defaultPurgeInterval is how often deleted messages are purged when
+// MESSAGE_PURGE_INTERVAL is not set.
+const defaultPurgeInterval = time.Hour
+
+// runPurge erases the text of the messages deleted for everyone longer than the
+// retention delay ago, every purge interval, until ctx is cancelled.
+func (s *Server) runPurge(ctx context.Context) {
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := s.messageService.PurgeDeleted(ctx, time.Now().Add(-s.messageRetention))
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error purging deleted messages: %v", err)
+			}
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted messages", purged)
+		}
+	}
+}
@@ -14,9 +14,10 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Load environment variables (ideally done once at startup)
 	_ = os.Getenv("JWT_SECRET")
 	_ = os.Getenv("SERVICE_NAME")
-	r := gin.Default()
+	r := gin.New()
 
-	r.Use(gin.Logger())
+	// The request log keeps access tokens sent in the query string out
+	r.Use(middleware.Logger(), gin.Recovery())
 
 	r.GET("/", s.HelloWorldHandler)
 
@@ -40,6 +41,55 @@ func (s *Server) RegisterRoutes() http.Handler {
 	//	authorized.POST("/users/upload-image", userController.UploadImageHandler)
 	//}
 
+	api := r.Group("/v1")
+	api.Use(middleware.VerifyToken(os.Getenv("SERVICE_NAME"), os.Getenv("JWT_SECRET")))
+
+	presenceController := controller.NewPresenceController(s.presenceService)
+	api.GET("/presence", presenceController.GetPresenceHandler)
+
+	conversationController := controller.NewConversationController(s.conversationService, s.ws)
+	api.GET("/conversations", conversationController.ListConversationsHandler)
+	api.POST("/conversations", conversationController.CreateGroupHandler)
+	api.POST("/conversations/:id/participants", conversationController.AddParticipantsHandler)
+	api.DELETE("/conversations/:id/participants/:userId", conversationController.RemoveParticipantHandler)
+	api.PATCH("/conversations/:id/participants/:userId", conversationController.SetRoleHandler)
+	api.POST("/conversations/:id/leave", conversationController.LeaveHandler)
+	api.PATCH("/conversations/:id/settings", conversationController.UpdateSettingsHandler)
+	api.PATCH("/conversations/:id/moderation", conversationController.SetModerationHandler)
+	api.POST("/conversations/:id/participants/:userId/mute", conversationController.MuteMemberHandler)
+
+	messageController := controller.NewMessageController(s.conversationService, s.messageService, s.ws)
+	api.GET("/conversations/:id/messages", messageController.ListMessagesHandler)
+	api.PATCH("/conversations/:id/messages/:messageId", messageController.EditMessageHandler)
+	api.DELETE("/conversations/:id/messages/:messageId", messageController.DeleteMessageHandler)
+	api.GET("/conversations/:id/messages/:messageId/revisions", messageController.EditHistoryHandler)
+	api.GET("/conversations/:id/messages/:messageId/thread", messageController.ThreadHandler)
+	api.POST("/conversations/:id/messages/:messageId/thread", messageController.ReplyHandler)
+	api.PUT("/conversations/:id/messages/:messageId/follow", messageController.FollowThreadHandler)
+	api.DELETE("/conversations/:id/messages/:messageId/follow", messageController.UnfollowThreadHandler)
+	api.PUT("/conversations/:id/messages/:messageId/reactions/:emoji", messageController.AddReactionHandler)
+	api.DELETE("/conversations/:id/messages/:messageId/reactions/:emoji", messageController.RemoveReactionHandler)
+	api.GET("/mentions", messageController.MentionsHandler)
+
+	exportController := controller.NewExportController(s.conversationService, s.exportService)
+	api.GET("/conversations/:id/export", exportController.ExportHandler)
+
+	inviteController := controller.NewInviteController(s.inviteService, s.ws)
+	api.POST("/conversations/:id/invites", inviteController.CreateInviteHandler)
+	api.GET("/conversations/:id/invites", inviteController.ListInvitesHandler)
+	api.DELETE("/conversations/:id/invites/:inviteId", inviteController.RevokeInviteHandler)
+	api.GET("/invites/:token", inviteController.PreviewInviteHandler)
+	api.POST("/invites/:token/join", inviteController.JoinInviteHandler)
+
+	channelController := controller.NewChannelController(s.channelService, s.ws)
+	api.POST("/channels", channelController.CreateChannelHandler)
+	api.GET("/channels", channelController.BrowseChannelsHandler)
+	api.POST("/channels/:id/join", channelController.JoinChannelHandler)
+	api.POST("/channels/:id/leave", channelController.LeaveChannelHandler)
+
+	// The WebSocket handshake authenticates itself: browsers cannot send an
+	// Authorization header on an upgrade, so the token may also arrive as the
+	// access_token query parameter or through Sec-WebSocket-Protocol.
 	r.GET("/ws", func(c *gin.Context) {
 		s.ws.HandleConnections(c.Writer, c.Request)
 	})
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -11,23 +14,48 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"simple-chat-app/internal/database"
+	"simple-chat-app/internal/migration"
 	"simple-chat-app/internal/service"
 	"simple-chat-app/internal/websocket"
 )
 
 type Server struct {
-	port int
-	db   *mongo.Database
-	ws   *websocket.MyWebSocketServer
+	port                int
+	shutdownTimeout     time.Duration
+	messageRetention    time.Duration
+	purgeInterval       time.Duration
+	db                  *mongo.Database
+	ws                  *websocket.MyWebSocketServer
+	conversationService *service.ConversationService
+	inviteService       *service.InviteService
+	channelService      *service.ChannelService
+	messageService      *service.MessageService
+	exportService       *service.ExportService
+	presenceService     *service.PresenceService
+	httpServer          *http.Server
 }
 
-func NewServer() *http.Server {
+func NewServer() *Server {
 	portStr := os.Getenv("PORT")
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		port = 8080
 	}
 
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+
+	messageRetention, err := time.ParseDuration(os.Getenv("MESSAGE_RETENTION"))
+	if err != nil || messageRetention < 0 {
+		messageRetention = defaultMessageRetention
+	}
+	purgeInterval, err := time.ParseDuration(os.Getenv("MESSAGE_PURGE_INTERVAL"))
+	if err != nil || purgeInterval <= 0 {
+		purgeInterval = defaultPurgeInterval
+	}
+
 	db, err := database.New()
 	if err != nil {
 		fmt.Printf("Error initializing database: %v\n", err)
@@ -35,17 +63,58 @@ func NewServer() *http.Server {
 	}
 
 	conversationService := service.NewConversationService(db)
-	messageService := service.NewMessageService(db)
-	ws := websocket.NewWebSocketServer(conversationService, messageService)
-	go ws.Start()
+	if err := conversationService.EnsureIndexes(context.Background()); err != nil {
+		fmt.Printf("Error creating conversation indexes: %v\n", err)
+		os.Exit(1)
+	}
+	inviteService := service.NewInviteService(db, conversationService)
+	if err := inviteService.EnsureIndexes(context.Background()); err != nil {
+		fmt.Printf("Error creating invite indexes: %v\n", err)
+		os.Exit(1)
+	}
+	channelService := service.NewChannelService(db, conversationService)
+	if err := channelService.EnsureIndexes(context.Background()); err != nil {
+		fmt.Printf("Error creating channel indexes: %v\n", err)
+		os.Exit(1)
+	}
+	redisClient, err := newRedisClient()
+	if err != nil {
+		fmt.Printf("Error initializing Redis: %v\n", err)
+		os.Exit(1)
+	}
+	presenceService := service.NewPresenceService(db, newPresenceStore(redisClient))
+	messageService := service.NewMessageService(db, presenceService)
+	// Messages from before sequence numbers would all collide in the unique seq index
+	summary, err := migration.NumberMessages(context.Background(), db)
+	if err != nil {
+		fmt.Printf("Error numbering messages: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("message-sequence-numbers: %s\n", summary)
+	if err := messageService.EnsureIndexes(context.Background()); err != nil {
+		fmt.Printf("Error creating message indexes: %v\n", err)
+		os.Exit(1)
+	}
+	broker := newBroker(redisClient)
+
+	ws := websocket.NewWebSocketServer(conversationService, messageService, presenceService, broker, os.Getenv("JWT_SECRET"), websocket.ConfigFromEnv())
 
 	newServer := &Server{
-		port: port,
-		db:   db,
-		ws:   ws,
+		port:                port,
+		shutdownTimeout:     shutdownTimeout,
+		messageRetention:    messageRetention,
+		purgeInterval:       purgeInterval,
+		db:                  db,
+		ws:                  ws,
+		conversationService: conversationService,
+		inviteService:       inviteService,
+		channelService:      channelService,
+		messageService:      messageService,
+		exportService:       service.NewExportService(db),
+		presenceService:     presenceService,
 	}
 
-	server := &http.Server{
+	newServer.httpServer = &http.Server{
 		Addr:         fmt.Sprintf(":%d", newServer.port),
 		Handler:      newServer.RegisterRoutes(),
 		IdleTimeout:  time.Minute,
@@ -53,5 +122,54 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return newServer
+}
+
+// Run serves the REST API and the WebSocket gateway on a single listener until ctx
+// is cancelled, then shuts down gracefully: it stops accepting connections, lets
+// in-flight requests finish, says goodbye to every WebSocket client, drains the
+// gateway and disconnects from MongoDB, all within SHUTDOWN_TIMEOUT (30s by default).
+// Meanwhile, the text of messages deleted for everyone is purged once MESSAGE_RETENTION
+// (30 days by default) has passed, checking every MESSAGE_PURGE_INTERVAL (1h by default).
+func (s *Server) Run(ctx context.Context) error {
+	s.ws.Start()
+
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		s.runPurge(ctx)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", s.httpServer.Addr)
+		serveErr <- s.httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("cannot start server: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s", s.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	var shutdownErr error
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		shutdownErr = errors.Join(shutdownErr, fmt.Errorf("http server: %w", err))
+	}
+	if err := s.ws.Shutdown(shutdownCtx); err != nil {
+		shutdownErr = errors.Join(shutdownErr, fmt.Errorf("websocket gateway: %w", err))
+	}
+	<-purgeDone
+	if err := s.db.Client().Disconnect(shutdownCtx); err != nil {
+		shutdownErr = errors.Join(shutdownErr, fmt.Errorf("database: %w", err))
+	}
+
+	if shutdownErr == nil {
+		log.Println("Server stopped")
+	}
+	return shutdownErr
 }
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/utils"
+)
+
+// Channel directory page sizes.
+const (
+	defaultChannelLimit = 20
+	maxChannelLimit     = 100
+)
+
+// ChannelService provides methods to create, discover and join channels.
+type ChannelService struct {
+	conversationCollection *mongo.Collection
+	userCollection         *mongo.Collection
+	conversationService    *ConversationService
+}
+
+// NewChannelService creates a new ChannelService with the given database.
+func NewChannelService(db *mongo.Database, conversationService *ConversationService) *ChannelService {
+	return &ChannelService{
+		conversationCollection: db.Collection("conversation"),
+		userCollection:         db.Collection("user"),
+		conversationService:    conversationService,
+	}
+}
+
+// ChannelSummary is how a channel appears in the channel directory.
+type ChannelSummary struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Topic       string             `bson:"topic,omitempty" json:"topic,omitempty"`
+	Avatar      string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	MemberCount int                `bson:"memberCount" json:"memberCount"`
+	Joined      bool               `bson:"joined" json:"joined"`
+}
+
+// ChannelPage is a page of the channel directory. NextCursor is empty on the last page.
+type ChannelPage struct {
+	Channels   []ChannelSummary `json:"channels"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+// EnsureIndexes creates the indexes the channel queries rely on.
+// Channel names are unique.
+func (chs *ChannelService) EnsureIndexes(ctx context.Context) error {
+	_, err := chs.conversationCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}).
+			SetPartialFilterExpression(bson.M{"type": model.ConversationTypeChannel}),
+	})
+	return err
+}
+
+// requireVerified makes sure the user has verified their email.
+func (chs *ChannelService) requireVerified(ctx context.Context, userID primitive.ObjectID) error {
+	var user model.User
+	opts := options.FindOne().SetProjection(bson.M{"verifiedEmail": 1})
+	err := chs.userCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return utils.NewUnauthenticatedError("User not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !user.VerifiedEmail {
+		return utils.NewForbiddenError("verify your email to use channels")
+	}
+	return nil
+}
+
+// Create creates a channel owned by ownerID. Visibility defaults to public.
+// Returns the created channel or an error if the operation fails.
+func (chs *ChannelService) Create(ctx context.Context, ownerID primitive.ObjectID, name, topic, visibility string) (*model.Conversation, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, utils.NewBadRequestError("name is required")
+	}
+	if visibility == "" {
+		visibility = model.VisibilityPublic
+	}
+	if visibility != model.VisibilityPublic && visibility != model.VisibilityPrivate {
+		return nil, utils.NewBadRequestError("visibility must be public or private")
+	}
+	if err := chs.requireVerified(ctx, ownerID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	channel := model.Conversation{
+		ID:           primitive.NewObjectID(),
+		Type:         model.ConversationTypeChannel,
+		Name:         name,
+		Topic:        topic,
+		Visibility:   visibility,
+		Participants: []model.Participant{{UserId: ownerID, Role: model.RoleOwner, JoinedAt: now}},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	_, err := chs.conversationCollection.InsertOne(ctx, channel)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, utils.NewConflictError("a channel with this name already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Browse lists the public channels, by name, whose name or topic contains the
+// search text, starting after the given cursor. An empty search lists them all.
+func (chs *ChannelService) Browse(ctx context.Context, userID primitive.ObjectID, search, cursor string, limit int) (*ChannelPage, error) {
+	if limit <= 0 {
+		limit = defaultChannelLimit
+	}
+	if limit > maxChannelLimit {
+		limit = maxChannelLimit
+	}
+	if err := chs.requireVerified(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"type": model.ConversationTypeChannel, "visibility": model.VisibilityPublic}
+	if search = strings.TrimSpace(search); search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"topic": pattern}}
+	}
+	if cursor != "" {
+		filter["name"] = bson.M{"$gt": cursor}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}}}},
+		{{Key: "$limit", Value: limit + 1}},
+		{{Key: "$project", Value: bson.M{
+			"name":        1,
+			"topic":       1,
+			"avatar":      1,
+			"memberCount": bson.M{"$size": "$participants"},
+			"joined":      bson.M{"$in": bson.A{userID, "$participants.userId"}},
+		}}},
+	}
+
+	opts := options.Aggregate().SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	results, err := chs.conversationCollection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := []ChannelSummary{}
+	if err := results.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+
+	page := &ChannelPage{Channels: channels}
+	if len(channels) > limit {
+		page.Channels = channels[:limit]
+		page.NextCursor = page.Channels[limit-1].Name
+	}
+	return page, nil
+}
+
+// Join adds the user to a public channel as a member. Private channels can only be
+// joined through an invite.
+// Returns the channel and whether the user joined it.
+func (chs *ChannelService) Join(ctx context.Context, convID, userID primitive.ObjectID) (*model.Conversation, bool, error) {
+	if err := chs.requireVerified(ctx, userID); err != nil {
+		return nil, false, err
+	}
+
+	channel, err := chs.conversationService.FindById(ctx, convID)
+	if err != nil {
+		return nil, false, err
+	}
+	if channel.Type != model.ConversationTypeChannel {
+		return nil, false, utils.NewBadRequestError("only channels can be joined this way")
+	}
+	if channel.Visibility != model.VisibilityPublic {
+		return nil, false, utils.NewForbiddenError("this channel is invite-only")
+	}
+
+	return chs.conversationService.JoinGroup(ctx, convID, userID, model.RoleMember)
+}
+
+// Leave removes the user from a channel they take part in.
+func (chs *ChannelService) Leave(ctx context.Context, convID, userID primitive.ObjectID) (*model.Conversation, error) {
+	return chs.conversationService.Leave(ctx, convID, userID)
+}
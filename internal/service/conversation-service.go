@@ -2,43 +2,111 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"os"
 	"simple-chat-app/internal/model"
 	"simple-chat-app/internal/utils"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// defaultMaxGroupSize is the participant cap of a group when MAX_GROUP_SIZE is not set.
+const defaultMaxGroupSize = 256
+
+// defaultMaxChannelSize is the participant cap of a channel when MAX_CHANNEL_SIZE is not set.
+const defaultMaxChannelSize = 10000
+
+// maxMembershipRetries bounds how often a membership change is retried after losing a race.
+const maxMembershipRetries = 3
+
 // ConversationService provides methods to manage conversations.
 type ConversationService struct {
 	conversationCollection *mongo.Collection
 	userCollection         *mongo.Collection
+	maxGroupSize           int
+	maxChannelSize         int
 }
 
 // NewConversationService creates a new ConversationService with the given database.
+// Groups are capped at MAX_GROUP_SIZE participants (256 by default) and channels
+// at MAX_CHANNEL_SIZE (10000 by default).
 func NewConversationService(db *mongo.Database) *ConversationService {
+	maxGroupSize, err := strconv.Atoi(os.Getenv("MAX_GROUP_SIZE"))
+	if err != nil || maxGroupSize < 2 {
+		maxGroupSize = defaultMaxGroupSize
+	}
+	maxChannelSize, err := strconv.Atoi(os.Getenv("MAX_CHANNEL_SIZE"))
+	if err != nil || maxChannelSize < 2 {
+		maxChannelSize = defaultMaxChannelSize
+	}
+
 	return &ConversationService{
 		conversationCollection: db.Collection("conversation"),
 		userCollection:         db.Collection("user"),
+		maxGroupSize:           maxGroupSize,
+		maxChannelSize:         maxChannelSize,
 	}
 }
 
+// EnsureIndexes creates the indexes the conversation queries rely on.
+// The unique directKey index guarantees a single direct conversation per pair of users.
+func (cs *ConversationService) EnsureIndexes(ctx context.Context) error {
+	_, err := cs.conversationCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "participants.userId", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "directKey", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"directKey": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
 // validateUserInput checks if the conversation has valid sender and receiver IDs.
 // Returns an error if either ID is missing.
-func (cs *ConversationService) validateUserInput(conversation model.Conversation) error {
-	if conversation.SenderId == primitive.NilObjectID || conversation.ReceiverId == primitive.NilObjectID {
-		return errors.New("senderId and receiverId are required")
+func (cs *ConversationService) validateUserInput(senderID, receiverID primitive.ObjectID) error {
+	if senderID == primitive.NilObjectID || receiverID == primitive.NilObjectID {
+		return utils.NewBadRequestError("senderId and receiverId are required")
+	}
+	if senderID == receiverID {
+		return utils.NewBadRequestError("senderId and receiverId must be different users")
 	}
 	return nil
 }
 
-// Create adds a new conversation to the database if it is valid and does not already exist.
-// Returns the created conversation or an error if the operation fails.
-func (cs *ConversationService) Create(conversation model.Conversation) (*model.Conversation, error) {
-	if err := cs.validateUserInput(conversation); err != nil {
+// usersExist checks that every given user exists.
+func (cs *ConversationService) usersExist(ctx context.Context, userIDs []primitive.ObjectID) error {
+	userFilter := bson.M{
+		"_id": bson.M{"$in": userIDs},
+	}
+
+	count, err := cs.userCollection.CountDocuments(ctx, userFilter)
+	if err != nil {
+		return err
+	}
+	if count < int64(len(userIDs)) {
+		return utils.NewBadRequestError("One or more users do not exist")
+	}
+	return nil
+}
+
+// Create returns the direct conversation between two users, creating it if it does
+// not exist yet. Either user may have started it.
+// Returns the conversation or an error if the operation fails.
+func (cs *ConversationService) Create(senderID, receiverID primitive.ObjectID) (*model.Conversation, error) {
+	if err := cs.validateUserInput(senderID, receiverID); err != nil {
 		return nil, err
 	}
 
@@ -46,57 +114,788 @@ func (cs *ConversationService) Create(conversation model.Conversation) (*model.C
 	defer cancel()
 
 	// Check if both sender and receiver exist
-	userFilter := bson.M{
-		"_id": bson.M{"$in": []primitive.ObjectID{conversation.SenderId, conversation.ReceiverId}},
+	if err := cs.usersExist(ctx, []primitive.ObjectID{senderID, receiverID}); err != nil {
+		return nil, err
 	}
 
-	count, err := cs.userCollection.CountDocuments(ctx, userFilter)
-	if err != nil || count < 2 {
-		return nil, utils.NewBadRequestError("One or both users do not exist")
+	now := time.Now()
+	key := model.DirectKey(senderID, receiverID)
+	newConversation := model.Conversation{
+		ID:        primitive.NewObjectID(),
+		Type:      model.ConversationTypeDirect,
+		DirectKey: key,
+		Participants: []model.Participant{
+			{UserId: senderID, Role: model.RoleMember, JoinedAt: now},
+			{UserId: receiverID, Role: model.RoleMember, JoinedAt: now},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	filter := bson.M{"directKey": key}
+	update := bson.M{"$setOnInsert": newConversation}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var conversation model.Conversation
+	err := cs.conversationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&conversation)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created the conversation between our lookup and insert
+		err = cs.conversationCollection.FindOne(ctx, filter).Decode(&conversation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &conversation, nil
+}
+
+// CreateGroup creates a group conversation owned by ownerID with the given members.
+// Returns the created conversation or an error if the operation fails.
+func (cs *ConversationService) CreateGroup(ctx context.Context, ownerID primitive.ObjectID, title, avatar string, memberIDs []primitive.ObjectID) (*model.Conversation, error) {
+	if title == "" {
+		return nil, utils.NewBadRequestError("title is required")
+	}
+
+	now := time.Now()
+	participants := []model.Participant{{UserId: ownerID, Role: model.RoleOwner, JoinedAt: now}}
+	userIDs := []primitive.ObjectID{ownerID}
+	for _, memberID := range uniqueIDs(memberIDs) {
+		if memberID == ownerID {
+			continue
+		}
+		participants = append(participants, model.Participant{UserId: memberID, Role: model.RoleMember, JoinedAt: now})
+		userIDs = append(userIDs, memberID)
+	}
+
+	if len(participants) > cs.maxGroupSize {
+		return nil, utils.NewBadRequestError(fmt.Sprintf("a group can have at most %d participants", cs.maxGroupSize))
+	}
+	if err := cs.usersExist(ctx, userIDs); err != nil {
+		return nil, err
+	}
+
+	conversation := model.Conversation{
+		ID:           primitive.NewObjectID(),
+		Type:         model.ConversationTypeGroup,
+		Title:        title,
+		Avatar:       avatar,
+		Participants: participants,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if _, err := cs.conversationCollection.InsertOne(ctx, conversation); err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// AddParticipants adds users to a group as members. Only owners and admins can add
+// participants, and the group cannot grow past the maximum group size.
+// Users who already take part are ignored.
+func (cs *ConversationService) AddParticipants(ctx context.Context, convID, actorID primitive.ObjectID, userIDs []primitive.ObjectID) (*model.Conversation, error) {
+	userIDs = uniqueIDs(userIDs)
+	if len(userIDs) == 0 {
+		return nil, utils.NewBadRequestError("userIds is required")
+	}
+	if err := cs.usersExist(ctx, userIDs); err != nil {
+		return nil, err
+	}
+
+	return cs.updateGroup(ctx, convID, actorID, func(conversation *model.Conversation) error {
+		if !conversation.IsAdmin(actorID) {
+			return utils.NewForbiddenError("only owners and admins can add participants")
+		}
+
+		now := time.Now()
+		for _, userID := range userIDs {
+			if !conversation.HasParticipant(userID) {
+				conversation.Participants = append(conversation.Participants, model.Participant{UserId: userID, Role: model.RoleMember, JoinedAt: now})
+			}
+		}
+
+		return cs.checkSize(conversation)
+	})
+}
+
+// RemoveParticipant removes a user from a group. Owners can remove anyone but
+// themselves; admins can only remove members. A group left without an owner, as
+// one that never had any, gets the participant picked by nextOwner as its owner.
+func (cs *ConversationService) RemoveParticipant(ctx context.Context, convID, actorID, userID primitive.ObjectID) (*model.Conversation, error) {
+	if actorID == userID {
+		return nil, utils.NewBadRequestError("use leave to remove yourself from a conversation")
+	}
+
+	return cs.updateGroup(ctx, convID, actorID, func(conversation *model.Conversation) error {
+		actor := conversation.Participant(actorID)
+		target := conversation.Participant(userID)
+		if !conversation.IsAdmin(actorID) {
+			return utils.NewForbiddenError("only owners and admins can remove participants")
+		}
+		if target == nil {
+			return utils.NewNotFoundError("user is not a participant of this conversation")
+		}
+		if target.Role != model.RoleMember && actor.Role != model.RoleOwner {
+			return utils.NewForbiddenError("only the owner can remove admins")
+		}
+
+		conversation.Participants = withoutParticipant(conversation.Participants, userID)
+		ensureOwner(conversation.Participants)
+		return nil
+	})
+}
+
+// Leave removes the user from a group. When the last owner leaves, ownership passes to
+// the longest-standing admin, or to the longest-standing member if there is no admin.
+func (cs *ConversationService) Leave(ctx context.Context, convID, userID primitive.ObjectID) (*model.Conversation, error) {
+	return cs.updateGroup(ctx, convID, userID, func(conversation *model.Conversation) error {
+		conversation.Participants = withoutParticipant(conversation.Participants, userID)
+		ensureOwner(conversation.Participants)
+		return nil
+	})
+}
+
+// SetRole changes the role of a participant of a group. Owners and admins can
+// promote members to admin; only the owner can demote admins or hand over
+// ownership, in which case the previous owner becomes an admin.
+func (cs *ConversationService) SetRole(ctx context.Context, convID, actorID, userID primitive.ObjectID, role string) (*model.Conversation, error) {
+	if role != model.RoleOwner && role != model.RoleAdmin && role != model.RoleMember {
+		return nil, utils.NewBadRequestError("role must be owner, admin or member")
+	}
+
+	return cs.updateGroup(ctx, convID, actorID, func(conversation *model.Conversation) error {
+		actor := conversation.Participant(actorID)
+		target := conversation.Participant(userID)
+		if !conversation.IsAdmin(actorID) {
+			return utils.NewForbiddenError("only owners and admins can change roles")
+		}
+		if target == nil {
+			return utils.NewNotFoundError("user is not a participant of this conversation")
+		}
+		if target.Role == role {
+			return nil
+		}
+
+		switch {
+		case role == model.RoleAdmin && target.Role == model.RoleMember:
+			// Any owner or admin can promote a member
+		case actor.Role != model.RoleOwner:
+			return utils.NewForbiddenError("only the owner can change this role")
+		case target.Role == model.RoleOwner:
+			return utils.NewBadRequestError("hand over ownership to another participant instead")
+		case role == model.RoleOwner:
+			actor.Role = model.RoleAdmin
+		}
+
+		target.Role = role
+		return nil
+	})
+}
+
+// ModerationUpdate changes some of the moderation rules of a group or channel;
+// nil fields are left as they are. A SlowModeSeconds of 0 turns slow mode off.
+type ModerationUpdate struct {
+	SlowModeSeconds   *int    `json:"slowModeSeconds,omitempty"`
+	AnnouncementsOnly *bool   `json:"announcementsOnly,omitempty"`
+	BroadcastMentions *string `json:"broadcastMentions,omitempty"`
+}
+
+// SetModeration changes the moderation rules of a group or channel. Only owners and
+// admins can change them.
+func (cs *ConversationService) SetModeration(ctx context.Context, convID, actorID primitive.ObjectID, update ModerationUpdate) (*model.Conversation, error) {
+	if update.SlowModeSeconds != nil && *update.SlowModeSeconds < 0 {
+		return nil, utils.NewBadRequestError("slowModeSeconds cannot be negative")
+	}
+	if update.BroadcastMentions != nil {
+		switch *update.BroadcastMentions {
+		case model.BroadcastMentionsMembers, model.BroadcastMentionsAdmins, model.BroadcastMentionsDisabled:
+		default:
+			return nil, utils.NewBadRequestError("broadcastMentions must be members, admins or disabled")
+		}
 	}
 
-	// Check if conversation exists
-	conversationFilter := bson.M{
-		"senderId":   conversation.SenderId,
-		"receiverId": conversation.ReceiverId,
+	return cs.updateGroup(ctx, convID, actorID, func(conversation *model.Conversation) error {
+		if !conversation.IsAdmin(actorID) {
+			return utils.NewForbiddenError("only owners and admins can change moderation rules")
+		}
+		if update.SlowModeSeconds != nil {
+			conversation.Moderation.SlowModeSeconds = *update.SlowModeSeconds
+		}
+		if update.AnnouncementsOnly != nil {
+			conversation.Moderation.AnnouncementsOnly = *update.AnnouncementsOnly
+		}
+		if update.BroadcastMentions != nil {
+			conversation.Moderation.BroadcastMentions = *update.BroadcastMentions
+			if conversation.Moderation.BroadcastMentions == model.BroadcastMentionsMembers {
+				conversation.Moderation.BroadcastMentions = ""
+			}
+		}
+		return nil
+	})
+}
+
+// MuteMember keeps a participant from posting for the given duration; a duration
+// that is not positive unmutes them. Owners and admins can mute members; only the
+// owner can mute admins.
+func (cs *ConversationService) MuteMember(ctx context.Context, convID, actorID, userID primitive.ObjectID, duration time.Duration) (*model.Conversation, error) {
+	if actorID == userID {
+		return nil, utils.NewBadRequestError("you cannot mute yourself")
 	}
 
-	var existingConv model.Conversation
-	err = cs.conversationCollection.FindOne(ctx, conversationFilter).Decode(&existingConv)
-	if err == nil {
-		return nil, utils.NewConflictError("Conversation already exists")
+	return cs.updateGroup(ctx, convID, actorID, func(conversation *model.Conversation) error {
+		actor := conversation.Participant(actorID)
+		target := conversation.Participant(userID)
+		if !conversation.IsAdmin(actorID) {
+			return utils.NewForbiddenError("only owners and admins can mute participants")
+		}
+		if target == nil {
+			return utils.NewNotFoundError("user is not a participant of this conversation")
+		}
+		if target.Role == model.RoleOwner || (target.Role == model.RoleAdmin && actor.Role != model.RoleOwner) {
+			return utils.NewForbiddenError("you cannot mute this participant")
+		}
+
+		if duration <= 0 {
+			target.MutedUntil = nil
+			return nil
+		}
+		mutedUntil := time.Now().Add(duration)
+		target.MutedUntil = &mutedUntil
+		return nil
+	})
+}
+
+// SettingsUpdate changes some of a participant's settings; nil fields are left as
+// they are. A MutedUntil that is not in the future unmutes the conversation.
+type SettingsUpdate struct {
+	MutedUntil        *time.Time `json:"mutedUntil,omitempty"`
+	Archived          *bool      `json:"archived,omitempty"`
+	Pinned            *bool      `json:"pinned,omitempty"`
+	PinOrder          *int       `json:"pinOrder,omitempty"`
+	NotificationLevel *string    `json:"notificationLevel,omitempty"`
+}
+
+// UpdateSettings changes the user's own settings for a conversation they take part in.
+// Returns the settings after the change.
+func (cs *ConversationService) UpdateSettings(ctx context.Context, convID, userID primitive.ObjectID, update SettingsUpdate) (*model.ParticipantSettings, error) {
+	if update.NotificationLevel != nil {
+		switch *update.NotificationLevel {
+		case model.NotificationAll, model.NotificationMentions, model.NotificationNone:
+		default:
+			return nil, utils.NewBadRequestError("notificationLevel must be all, mentions or none")
+		}
 	}
-	if err != mongo.ErrNoDocuments {
-		return nil, errors.New("internal server error")
+	if _, err := cs.Authorize(ctx, convID, userID); err != nil {
+		return nil, err
 	}
 
-	conversation.ID = primitive.NewObjectID()
-	conversation.CreatedAt = time.Now()
-	conversation.UpdatedAt = time.Now()
+	now := time.Now()
+	set := bson.M{"updatedAt": now}
+	unset := bson.M{}
+	if update.MutedUntil != nil {
+		if update.MutedUntil.After(now) {
+			set["participants.$.settings.mutedUntil"] = *update.MutedUntil
+		} else {
+			unset["participants.$.settings.mutedUntil"] = ""
+		}
+	}
+	if update.Archived != nil {
+		set["participants.$.settings.archived"] = *update.Archived
+	}
+	if update.Pinned != nil {
+		set["participants.$.settings.pinned"] = *update.Pinned
+	}
+	if update.PinOrder != nil {
+		set["participants.$.settings.pinOrder"] = *update.PinOrder
+	}
+	if update.NotificationLevel != nil {
+		set["participants.$.settings.notificationLevel"] = *update.NotificationLevel
+	}
+
+	// Bumping updatedAt makes a concurrent membership change retry instead of
+	// writing back the participants with the old settings
+	changes := bson.M{"$set": set}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
 
-	_, err = cs.conversationCollection.InsertOne(ctx, conversation)
+	var conversation model.Conversation
+	err := cs.conversationCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": convID, "participants.userId": userID},
+		changes,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&conversation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.NewForbiddenError("you are not a participant of this conversation")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &conversation, nil
+	return &conversation.Participant(userID).Settings, nil
+}
+
+// checkSize makes sure a group or channel did not grow past its maximum size.
+func (cs *ConversationService) checkSize(conversation *model.Conversation) error {
+	if conversation.Type == model.ConversationTypeChannel {
+		if len(conversation.Participants) > cs.maxChannelSize {
+			return utils.NewBadRequestError(fmt.Sprintf("a channel can have at most %d participants", cs.maxChannelSize))
+		}
+		return nil
+	}
+	if len(conversation.Participants) > cs.maxGroupSize {
+		return utils.NewBadRequestError(fmt.Sprintf("a group can have at most %d participants", cs.maxGroupSize))
+	}
+	return nil
+}
+
+// JoinGroup adds a user to a group with the given role on their own behalf, as when
+// they follow an invite link. Returns whether the user was added; joining a group
+// one already takes part in changes nothing.
+func (cs *ConversationService) JoinGroup(ctx context.Context, convID, userID primitive.ObjectID, role string) (*model.Conversation, bool, error) {
+	joined := false
+	conversation, err := cs.saveGroup(ctx, convID, cs.FindById, func(conversation *model.Conversation) error {
+		joined = !conversation.HasParticipant(userID)
+		if !joined {
+			return nil
+		}
+
+		conversation.Participants = append(conversation.Participants, model.Participant{UserId: userID, Role: role, JoinedAt: time.Now()})
+		return cs.checkSize(conversation)
+	})
+	return conversation, joined, err
+}
+
+// updateGroup applies a membership change made by actorID, who must take part in the group.
+func (cs *ConversationService) updateGroup(ctx context.Context, convID, actorID primitive.ObjectID, change func(conversation *model.Conversation) error) (*model.Conversation, error) {
+	load := func(ctx context.Context, convID primitive.ObjectID) (*model.Conversation, error) {
+		return cs.Authorize(ctx, convID, actorID)
+	}
+	return cs.saveGroup(ctx, convID, load, change)
 }
 
-// GetConversationWithUsers retrieves a conversation and its associated users by conversation ID.
-// Returns the conversation, sender, receiver, and an error if the operation fails.
-func (cs *ConversationService) GetConversationWithUsers(ctx context.Context, convID primitive.ObjectID) (*model.Conversation, *model.User, *model.User, error) {
+// saveGroup applies a membership change to the group returned by load with optimistic concurrency:
+// the change is computed on the current document and only saved if nobody else
+// updated the conversation meanwhile, otherwise it is retried on the fresh document.
+func (cs *ConversationService) saveGroup(ctx context.Context, convID primitive.ObjectID, load func(ctx context.Context, convID primitive.ObjectID) (*model.Conversation, error), change func(conversation *model.Conversation) error) (*model.Conversation, error) {
+	for attempt := 0; attempt < maxMembershipRetries; attempt++ {
+		conversation, err := load(ctx, convID)
+		if err != nil {
+			return nil, err
+		}
+		if conversation.Type == model.ConversationTypeDirect {
+			return nil, utils.NewBadRequestError("the participants of a direct conversation cannot change")
+		}
+
+		previousUpdate := conversation.UpdatedAt
+		if err := change(conversation); err != nil {
+			return nil, err
+		}
+		conversation.UpdatedAt = time.Now()
+
+		filter := bson.M{"_id": convID, "updatedAt": previousUpdate}
+		update := bson.M{
+			"$set": bson.M{
+				"participants": conversation.Participants,
+				"moderation":   conversation.Moderation,
+				"updatedAt":    conversation.UpdatedAt,
+			},
+		}
+		result, err := cs.conversationCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount > 0 {
+			return conversation, nil
+		}
+		log.Printf("Conversation %s changed concurrently, retrying", convID.Hex())
+	}
+	return nil, utils.NewConflictError("the conversation is being changed by someone else, try again")
+}
+
+// FindById retrieves a conversation by its ID.
+// Returns a not found error if the conversation does not exist.
+func (cs *ConversationService) FindById(ctx context.Context, convID primitive.ObjectID) (*model.Conversation, error) {
 	var conversation model.Conversation
-	if err := cs.conversationCollection.FindOne(ctx, bson.M{"_id": convID}).Decode(&conversation); err != nil {
-		return nil, nil, nil, err
+	err := cs.conversationCollection.FindOne(ctx, bson.M{"_id": convID}).Decode(&conversation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.NewNotFoundError("Conversation not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// Authorize retrieves a conversation on behalf of a user. Every conversation and
+// message operation goes through it: only participants may see or act on a conversation.
+// Returns a not found error if the conversation does not exist, and a forbidden error
+// if the user does not take part in it.
+func (cs *ConversationService) Authorize(ctx context.Context, convID, userID primitive.ObjectID) (*model.Conversation, error) {
+	conversation, err := cs.FindById(ctx, convID)
+	if err != nil {
+		return nil, err
+	}
+	if !conversation.HasParticipant(userID) {
+		return nil, utils.NewForbiddenError("you are not a participant of this conversation")
+	}
+	return conversation, nil
+}
+
+// GetConversationWithUsers retrieves a conversation and the profiles of the users taking
+// part in it by conversation ID, on behalf of a participant.
+// Returns the conversation, its participants' profiles, and an error if the operation fails.
+func (cs *ConversationService) GetConversationWithUsers(ctx context.Context, convID, userID primitive.ObjectID) (*model.Conversation, []model.UserProfile, error) {
+	conversation, err := cs.Authorize(ctx, convID, userID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var sender, receiver model.User
-	if err := cs.userCollection.FindOne(ctx, bson.M{"_id": conversation.SenderId}).Decode(&sender); err != nil {
-		return &conversation, nil, nil, err
+	opts := options.Find().SetProjection(model.UserProfileProjection)
+	cursor, err := cs.userCollection.Find(ctx, bson.M{"_id": bson.M{"$in": conversation.ParticipantIDs()}}, opts)
+	if err != nil {
+		return conversation, nil, err
 	}
-	if err := cs.userCollection.FindOne(ctx, bson.M{"_id": conversation.ReceiverId}).Decode(&receiver); err != nil {
-		return &conversation, &sender, nil, err
+
+	users := []model.UserProfile{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return conversation, nil, err
 	}
 
-	return &conversation, &sender, &receiver, nil
+	return conversation, users, nil
+}
+
+// uniqueIDs returns the IDs without duplicates or nil IDs, in their original order.
+func uniqueIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if id.IsZero() || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	return unique
+}
+
+// withoutParticipant returns the participants without the given user.
+func withoutParticipant(participants []model.Participant, userID primitive.ObjectID) []model.Participant {
+	remaining := make([]model.Participant, 0, len(participants))
+	for _, participant := range participants {
+		if participant.UserId != userID {
+			remaining = append(remaining, participant)
+		}
+	}
+	return remaining
+}
+
+// ensureOwner makes sure a group with participants has an owner, promoting the
+// participant picked by nextOwner when none of them is.
+func ensureOwner(participants []model.Participant) {
+	for _, participant := range participants {
+		if participant.Role == model.RoleOwner {
+			return
+		}
+	}
+	if successor := nextOwner(participants); successor != nil {
+		successor.Role = model.RoleOwner
+	}
+}
+
+// nextOwner picks who inherits a group whose owner left: the admin who joined
+// first, or the member who joined first if there is no admin.
+func nextOwner(participants []model.Participant) *model.Participant {
+	var successor *model.Participant
+	for i := range participants {
+		candidate := &participants[i]
+		if successor == nil ||
+			(candidate.Role == model.RoleAdmin && successor.Role != model.RoleAdmin) ||
+			(candidate.Role == successor.Role && candidate.JoinedAt.Before(successor.JoinedAt)) {
+			successor = candidate
+		}
+	}
+	return successor
+}
+
+// Inbox page sizes.
+const (
+	defaultInboxLimit = 20
+	maxInboxLimit     = 100
+)
+
+// inboxChannelSenders is how many of a channel's latest messages have their senders'
+// profiles listed in the inbox; channels are too large to list everyone.
+const inboxChannelSenders = 20
+
+// InboxQuery selects a page of a user's inbox. Archived conversations are only
+// listed, alone, when Archived is set; Pinned lists only pinned conversations.
+type InboxQuery struct {
+	Cursor   string
+	Limit    int
+	Archived bool
+	Pinned   bool
+}
+
+// InboxEntry is one conversation of a user's inbox, with its participants' profiles,
+// a preview of its last message, how many messages the user has not read yet and
+// the user's own settings for it. Only the user's own read pointer is included. Channels
+// only list the user among their participants, and the profiles of the user and of
+// the senders of the latest messages; MemberCount says how many participants there are.
+type InboxEntry struct {
+	model.Conversation `bson:",inline"`
+	MemberCount        int                       `bson:"memberCount" json:"memberCount"`
+	Users              []model.UserProfile       `bson:"users" json:"users"`
+	LastMessage        *model.Message            `bson:"lastMessage,omitempty" json:"lastMessage"`
+	UnreadCount        int64                     `bson:"unreadCount" json:"unreadCount"`
+	Settings           model.ParticipantSettings `bson:"settings" json:"settings"`
+	ActivityAt         time.Time                 `bson:"activityAt" json:"activityAt"`
+	PinRank            int                       `bson:"pinRank" json:"-"`
+	PinOrder           int                       `bson:"pinOrder" json:"-"`
+}
+
+// InboxPage is a page of a user's inbox. NextCursor is empty on the last page.
+type InboxPage struct {
+	Conversations []InboxEntry `json:"conversations"`
+	NextCursor    string       `json:"nextCursor,omitempty"`
+}
+
+// inboxCursor is the sort position of the last entry of an inbox page.
+type inboxCursor struct {
+	PinRank    int    `json:"r"`
+	PinOrder   int    `json:"o"`
+	ActivityAt int64  `json:"t"`
+	ID         string `json:"id"`
+}
+
+// Inbox lists the conversations of a user: pinned ones first, by their pin order,
+// then the others, most recently active first. A conversation is active when it
+// gets a message, or when it is created if it has none yet.
+// The page is built by a single aggregation pipeline.
+func (cs *ConversationService) Inbox(ctx context.Context, userID primitive.ObjectID, query InboxQuery) (*InboxPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultInboxLimit
+	}
+	if limit > maxInboxLimit {
+		limit = maxInboxLimit
+	}
+
+	pinned := bson.M{"$eq": bson.A{"$settings.pinned", true}}
+	isChannel := bson.M{"$eq": bson.A{"$type", model.ConversationTypeChannel}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"participants.userId": userID}}},
+		// The user's own settings, and the sort keys derived from them
+		{{Key: "$addFields", Value: bson.M{
+			"activityAt": bson.M{"$ifNull": bson.A{"$lastMessageAt", "$createdAt"}},
+			"settings": bson.M{"$ifNull": bson.A{
+				bson.M{"$arrayElemAt": bson.A{
+					bson.M{"$map": bson.M{
+						"input": bson.M{"$filter": bson.M{
+							"input": "$participants",
+							"cond":  bson.M{"$eq": bson.A{"$$this.userId", userID}},
+						}},
+						"in": "$$this.settings",
+					}},
+					0,
+				}},
+				bson.M{},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"pinRank":  bson.M{"$cond": bson.A{pinned, 0, 1}},
+			"pinOrder": bson.M{"$cond": bson.A{pinned, bson.M{"$ifNull": bson.A{"$settings.pinOrder", 0}}, 0}},
+		}}},
+	}
+
+	filter := bson.M{"settings.archived": bson.M{"$ne": true}}
+	if query.Archived {
+		filter = bson.M{"settings.archived": true}
+	}
+	if query.Pinned {
+		filter["pinRank"] = 0
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+
+	if query.Cursor != "" {
+		cursor, err := decodeInboxCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: afterInboxCursor(cursor)}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{
+			{Key: "pinRank", Value: 1},
+			{Key: "pinOrder", Value: 1},
+			{Key: "activityAt", Value: -1},
+			{Key: "_id", Value: -1},
+		}}},
+		bson.D{{Key: "$limit", Value: limit + 1}},
+		// The senders of a channel's latest messages
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from": "message",
+			"let":  bson.M{"conversationId": "$_id", "type": "$type"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$type", model.ConversationTypeChannel}},
+					bson.M{"$eq": bson.A{"$conversationId", "$$conversationId"}},
+				}}}},
+				bson.M{"$sort": bson.M{"seq": -1}},
+				bson.M{"$limit": inboxChannelSenders},
+				bson.M{"$project": bson.M{"senderId": 1}},
+			},
+			"as": "recentSenders",
+		}}},
+		// Other users only see their own read pointer, and a channel only its member count
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"memberCount": bson.M{"$size": "$participants"},
+			"profileIds": bson.M{"$cond": bson.A{
+				isChannel,
+				bson.M{"$setUnion": bson.A{"$recentSenders.senderId", bson.A{userID}}},
+				"$participants.userId",
+			}},
+			"participants": bson.M{"$cond": bson.A{
+				isChannel,
+				bson.M{"$filter": bson.M{"input": "$participants", "cond": bson.M{"$eq": bson.A{"$$this.userId", userID}}}},
+				"$participants",
+			}},
+			"lastRead": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$lastRead", bson.A{}}},
+				"cond":  bson.M{"$eq": bson.A{"$$this.userId", userID}},
+			}},
+		}}},
+		// Participants' public profiles
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from": "user",
+			"let":  bson.M{"ids": "$profileIds"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$in": bson.A{"$_id", "$$ids"}}}},
+				bson.M{"$project": model.UserProfileProjection},
+			},
+			"as": "users",
+		}}},
+		// The last message is the latest one of the main timeline the user can see,
+		// thread replies staying in their thread
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from": "message",
+			"let":  bson.M{"conversationId": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"$expr":        bson.M{"$eq": bson.A{"$conversationId", "$$conversationId"}},
+					"threadRootId": bson.M{"$exists": false},
+					"hiddenFor":    bson.M{"$ne": userID},
+				}},
+				bson.M{"$sort": bson.M{"seq": -1}},
+				bson.M{"$limit": 1},
+				bson.M{"$project": bson.M{"receipts": 0, "revisions": 0, "hiddenFor": 0}},
+			},
+			"as": "lastMessage",
+		}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"lastMessage": bson.M{"$arrayElemAt": bson.A{"$lastMessage", 0}},
+			"readSeq": bson.M{"$ifNull": bson.A{
+				bson.M{"$arrayElemAt": bson.A{
+					bson.M{"$map": bson.M{
+						"input": bson.M{"$filter": bson.M{
+							"input": bson.M{"$ifNull": bson.A{"$lastRead", bson.A{}}},
+							"cond":  bson.M{"$eq": bson.A{"$$this.userId", userID}},
+						}},
+						"in": "$$this.seq",
+					}},
+					0,
+				}},
+				0,
+			}},
+		}}},
+		// Unread messages are the ones from others past the user's read pointer, leaving
+		// out thread replies, messages deleted for everyone and the ones the user hid
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from": "message",
+			"let":  bson.M{"conversationId": "$_id", "readSeq": "$readSeq"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"$expr": bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$conversationId", "$$conversationId"}},
+						bson.M{"$gt": bson.A{"$seq", "$$readSeq"}},
+						bson.M{"$ne": bson.A{"$senderId", userID}},
+					}},
+					"threadRootId": bson.M{"$exists": false},
+					"deletedAt":    bson.M{"$exists": false},
+					"hiddenFor":    bson.M{"$ne": userID},
+				}},
+				bson.M{"$count": "count"},
+			},
+			"as": "unread",
+		}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"unreadCount": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$unread.count", 0}}, 0}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"unread": 0, "readSeq": 0, "recentSenders": 0, "profileIds": 0, "lastPostedAt": 0}}},
+	)
+
+	results, err := cs.conversationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []InboxEntry{}
+	if err := results.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if last := entries[i].LastMessage; last != nil {
+			last.Redact()
+		}
+	}
+
+	page := &InboxPage{Conversations: entries}
+	if len(entries) > limit {
+		page.Conversations = entries[:limit]
+		page.NextCursor = encodeInboxCursor(page.Conversations[limit-1])
+	}
+	return page, nil
+}
+
+// afterInboxCursor matches the entries sorted after the cursor position.
+func afterInboxCursor(cursor *inboxCursor) bson.M {
+	activityAt := time.UnixMilli(cursor.ActivityAt)
+	id, _ := primitive.ObjectIDFromHex(cursor.ID)
+
+	return bson.M{"$or": bson.A{
+		bson.M{"pinRank": bson.M{"$gt": cursor.PinRank}},
+		bson.M{"pinRank": cursor.PinRank, "pinOrder": bson.M{"$gt": cursor.PinOrder}},
+		bson.M{"pinRank": cursor.PinRank, "pinOrder": cursor.PinOrder, "activityAt": bson.M{"$lt": activityAt}},
+		bson.M{"pinRank": cursor.PinRank, "pinOrder": cursor.PinOrder, "activityAt": activityAt, "_id": bson.M{"$lt": id}},
+	}}
+}
+
+// encodeInboxCursor turns the position of the last entry of a page into an opaque cursor.
+func encodeInboxCursor(entry InboxEntry) string {
+	raw, _ := json.Marshal(inboxCursor{
+		PinRank:    entry.PinRank,
+		PinOrder:   entry.PinOrder,
+		ActivityAt: entry.ActivityAt.UnixMilli(),
+		ID:         entry.ID.Hex(),
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeInboxCursor reads back a cursor made by encodeInboxCursor.
+func decodeInboxCursor(cursor string) (*inboxCursor, error) {
+	invalid := utils.NewBadRequestError("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	var decoded inboxCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, invalid
+	}
+	if !primitive.IsValidObjectID(decoded.ID) {
+		return nil, invalid
+	}
+	return &decoded, nil
 }
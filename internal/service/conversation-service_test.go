@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+func TestEnsureOwnerPromotesWhenTheLastOwnerIsGone(t *testing.T) {
+	now := time.Now()
+	member := model.Participant{UserId: primitive.NewObjectID(), Role: model.RoleMember, JoinedAt: now.Add(-3 * time.Hour)}
+	oldAdmin := model.Participant{UserId: primitive.NewObjectID(), Role: model.RoleAdmin, JoinedAt: now.Add(-2 * time.Hour)}
+	newAdmin := model.Participant{UserId: primitive.NewObjectID(), Role: model.RoleAdmin, JoinedAt: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name         string
+		participants []model.Participant
+		want         primitive.ObjectID
+	}{
+		{"oldest admin", []model.Participant{member, newAdmin, oldAdmin}, oldAdmin.UserId},
+		{"oldest member without admins", []model.Participant{member, {UserId: newAdmin.UserId, Role: model.RoleMember, JoinedAt: newAdmin.JoinedAt}}, member.UserId},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ensureOwner(test.participants)
+
+			var owners []primitive.ObjectID
+			for _, participant := range test.participants {
+				if participant.Role == model.RoleOwner {
+					owners = append(owners, participant.UserId)
+				}
+			}
+			if len(owners) != 1 || owners[0] != test.want {
+				t.Fatalf("owners = %v, want [%s]", owners, test.want.Hex())
+			}
+		})
+	}
+}
+
+func TestEnsureOwnerKeepsAnExistingOwner(t *testing.T) {
+	participants := []model.Participant{
+		{UserId: primitive.NewObjectID(), Role: model.RoleAdmin, JoinedAt: time.Now().Add(-time.Hour)},
+		{UserId: primitive.NewObjectID(), Role: model.RoleOwner, JoinedAt: time.Now()},
+	}
+	ensureOwner(participants)
+
+	if participants[0].Role != model.RoleAdmin || participants[1].Role != model.RoleOwner {
+		t.Fatalf("roles changed to %s and %s", participants[0].Role, participants[1].Role)
+	}
+}
+
+func TestEnsureOwnerWithoutParticipants(t *testing.T) {
+	ensureOwner(nil)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/export"
+	"simple-chat-app/internal/model"
+)
+
+// exportBatchSize is how many messages are read from the database at a time during an export.
+const exportBatchSize = 500
+
+// ExportService streams conversation transcripts.
+type ExportService struct {
+	messageCollection *mongo.Collection
+	userCollection    *mongo.Collection
+}
+
+// NewExportService creates a new ExportService with the given database.
+func NewExportService(db *mongo.Database) *ExportService {
+	return &ExportService{
+		messageCollection: db.Collection("message"),
+		userCollection:    db.Collection("user"),
+	}
+}
+
+// ExportOptions select the format and messages of a transcript. Times are written
+// in Location; From and To, when set, bound the creation time of the messages
+// exported, To being exclusive.
+type ExportOptions struct {
+	Format   string
+	Location *time.Location
+	From, To time.Time
+}
+
+// Export writes the messages of a conversation to w, oldest first, in the requested
+// format. Messages are read in batches and written as they arrive, so the history
+// is never held in memory as a whole. Messages deleted for everyone are left out.
+func (es *ExportService) Export(ctx context.Context, w io.Writer, conversation *model.Conversation, opts ExportOptions) error {
+	writer, err := export.NewWriter(opts.Format, w, export.Header{
+		ConversationId: conversation.ID.Hex(),
+		Title:          transcriptTitle(conversation),
+		Location:       opts.Location,
+		From:           opts.From,
+		To:             opts.To,
+	})
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"conversationId": conversation.ID, "deletedAt": bson.M{"$exists": false}}
+	createdAt := bson.M{}
+	if !opts.From.IsZero() {
+		createdAt["$gte"] = opts.From
+	}
+	if !opts.To.IsZero() {
+		createdAt["$lt"] = opts.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "seq", Value: 1}}).
+		SetProjection(bson.M{"receipts": 0}).
+		SetBatchSize(exportBatchSize)
+	cursor, err := es.messageCollection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	names, err := es.usernames(ctx, conversation.ParticipantIDs())
+	if err != nil {
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var message model.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+
+		// Former participants are looked up as they show up
+		name, ok := names[message.SenderId]
+		if !ok {
+			found, err := es.usernames(ctx, []primitive.ObjectID{message.SenderId})
+			if err != nil {
+				return err
+			}
+			name, ok = found[message.SenderId]
+			if !ok {
+				name = "Deleted user"
+			}
+			names[message.SenderId] = name
+		}
+
+		err := writer.WriteEntry(export.Entry{
+			Seq:        message.Seq,
+			At:         message.CreatedAt,
+			SenderId:   message.SenderId.Hex(),
+			SenderName: name,
+			Message:    message.Message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// usernames returns the usernames of the given users that exist.
+func (es *ExportService) usernames(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	opts := options.Find().SetProjection(bson.M{"username": 1})
+	cursor, err := es.userCollection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []model.UserProfile
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	names := make(map[primitive.ObjectID]string, len(users))
+	for _, user := range users {
+		names[user.ID] = user.Username
+	}
+	return names, nil
+}
+
+// transcriptTitle names a conversation at the top of its transcript.
+func transcriptTitle(conversation *model.Conversation) string {
+	switch {
+	case conversation.Name != "":
+		return "#" + conversation.Name
+	case conversation.Title != "":
+		return conversation.Title
+	default:
+		return "Conversation " + conversation.ID.Hex()
+	}
+}
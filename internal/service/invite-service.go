@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/utils"
+)
+
+// InviteService provides methods to manage the invite links of group conversations.
+type InviteService struct {
+	inviteCollection    *mongo.Collection
+	conversationService *ConversationService
+}
+
+// NewInviteService creates a new InviteService with the given database.
+func NewInviteService(db *mongo.Database, conversationService *ConversationService) *InviteService {
+	return &InviteService{
+		inviteCollection:    db.Collection("invite"),
+		conversationService: conversationService,
+	}
+}
+
+// InviteOptions are the settings of a new invite. ExpiresIn of 0 means the invite
+// never expires, MaxUses of 0 that it can be used any number of times, and an
+// empty Role that users join as members.
+type InviteOptions struct {
+	ExpiresIn time.Duration
+	MaxUses   int
+	Role      string
+}
+
+// EnsureIndexes creates the indexes the invite queries rely on.
+func (is *InviteService) EnsureIndexes(ctx context.Context) error {
+	_, err := is.inviteCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "conversationId", Value: 1}},
+		},
+	})
+	return err
+}
+
+// authorizeAdmin retrieves a group or channel on behalf of one of its owners or admins.
+func (is *InviteService) authorizeAdmin(ctx context.Context, convID, actorID primitive.ObjectID) (*model.Conversation, error) {
+	conversation, err := is.conversationService.Authorize(ctx, convID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Type == model.ConversationTypeDirect {
+		return nil, utils.NewBadRequestError("only groups and channels have invites")
+	}
+	if !conversation.IsAdmin(actorID) {
+		return nil, utils.NewForbiddenError("only owners and admins can manage invites")
+	}
+	return conversation, nil
+}
+
+// Create makes a new invite to a group. Only owners and admins can create invites,
+// and only the owner can create invites that make users admins.
+// Returns the created invite or an error if the operation fails.
+func (is *InviteService) Create(ctx context.Context, convID, actorID primitive.ObjectID, opts InviteOptions) (*model.Invite, error) {
+	if opts.Role == "" {
+		opts.Role = model.RoleMember
+	}
+	if opts.Role != model.RoleMember && opts.Role != model.RoleAdmin {
+		return nil, utils.NewBadRequestError("role must be admin or member")
+	}
+	if opts.ExpiresIn < 0 || opts.MaxUses < 0 {
+		return nil, utils.NewBadRequestError("expiresIn and maxUses cannot be negative")
+	}
+
+	conversation, err := is.authorizeAdmin(ctx, convID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Role == model.RoleAdmin && conversation.Participant(actorID).Role != model.RoleOwner {
+		return nil, utils.NewForbiddenError("only the owner can invite admins")
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := model.Invite{
+		ID:             primitive.NewObjectID(),
+		Token:          token,
+		ConversationId: convID,
+		CreatedBy:      actorID,
+		Role:           opts.Role,
+		MaxUses:        opts.MaxUses,
+		CreatedAt:      now,
+	}
+	if opts.ExpiresIn > 0 {
+		expiresAt := now.Add(opts.ExpiresIn)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if _, err := is.inviteCollection.InsertOne(ctx, invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// List returns the invites of a group that can still be used, newest first.
+// Only owners and admins can list invites.
+func (is *InviteService) List(ctx context.Context, convID, actorID primitive.ObjectID) ([]model.Invite, error) {
+	if _, err := is.authorizeAdmin(ctx, convID, actorID); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := is.inviteCollection.Find(ctx, bson.M{"conversationId": convID, "revokedAt": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []model.Invite
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invites := []model.Invite{}
+	for _, invite := range all {
+		if invite.Usable(now) {
+			invites = append(invites, invite)
+		}
+	}
+	return invites, nil
+}
+
+// Revoke makes an invite of a group unusable. Only owners and admins can revoke invites.
+func (is *InviteService) Revoke(ctx context.Context, convID, inviteID, actorID primitive.ObjectID) error {
+	if _, err := is.authorizeAdmin(ctx, convID, actorID); err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": inviteID, "conversationId": convID, "revokedAt": bson.M{"$exists": false}}
+	result, err := is.inviteCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return utils.NewNotFoundError("Invite not found")
+	}
+	return nil
+}
+
+// findUsable retrieves an invite by its token, as long as it can still be used.
+func (is *InviteService) findUsable(ctx context.Context, token string) (*model.Invite, error) {
+	var invite model.Invite
+	err := is.inviteCollection.FindOne(ctx, bson.M{"token": token}).Decode(&invite)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.NewNotFoundError("Invite not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !invite.Usable(time.Now()) {
+		return nil, utils.NewNotFoundError("Invite has expired")
+	}
+	return &invite, nil
+}
+
+// Preview describes the group or channel an invite leads to, so users can decide to
+// join it. Channels have a name and a topic rather than a title.
+func (is *InviteService) Preview(ctx context.Context, token string) (*model.InvitePreview, error) {
+	invite, err := is.findUsable(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, err := is.conversationService.FindById(ctx, invite.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	title := conversation.Title
+	if conversation.Type == model.ConversationTypeChannel && title == "" {
+		title = conversation.Name
+	}
+
+	return &model.InvitePreview{
+		ConversationId: conversation.ID,
+		Type:           conversation.Type,
+		Title:          title,
+		Topic:          conversation.Topic,
+		Avatar:         conversation.Avatar,
+		MemberCount:    len(conversation.Participants),
+		Role:           invite.Role,
+		ExpiresAt:      invite.ExpiresAt,
+	}, nil
+}
+
+// Join adds the user to the group of an invite with the invite's role. A use is only
+// counted when the user was not already in the group.
+// Returns the group and whether the user joined it.
+func (is *InviteService) Join(ctx context.Context, token string, userID primitive.ObjectID) (*model.Conversation, bool, error) {
+	invite, err := is.findUsable(ctx, token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conversation, err := is.conversationService.FindById(ctx, invite.ConversationId)
+	if err != nil {
+		return nil, false, err
+	}
+	if conversation.HasParticipant(userID) {
+		return conversation, false, nil
+	}
+
+	// Claim a use; the filter makes sure concurrent joins cannot exceed maxUses
+	now := time.Now()
+	claim := bson.M{
+		"_id":       invite.ID,
+		"revokedAt": bson.M{"$exists": false},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"expiresAt": bson.M{"$exists": false}}, bson.M{"expiresAt": bson.M{"$gt": now}}}},
+			bson.M{"$or": bson.A{bson.M{"maxUses": 0}, bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxUses"}}}}},
+		},
+	}
+	result, err := is.inviteCollection.UpdateOne(ctx, claim, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return nil, false, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, false, utils.NewNotFoundError("Invite has expired")
+	}
+
+	conversation, joined, err := is.conversationService.JoinGroup(ctx, invite.ConversationId, userID, invite.Role)
+	if err != nil || !joined {
+		// Give the use back
+		if _, releaseErr := is.inviteCollection.UpdateOne(ctx, bson.M{"_id": invite.ID}, bson.M{"$inc": bson.M{"uses": -1}}); releaseErr != nil {
+			return nil, false, errors.Join(err, releaseErr)
+		}
+	}
+	return conversation, joined, err
+}
@@ -3,37 +3,136 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"regexp"
 	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/utils"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// maxReactionsPerMessage caps the number of different emojis a message can be reacted with.
+const maxReactionsPerMessage = 20
+
+// maxEmojiLength bounds the size of a reaction emoji, in bytes.
+const maxEmojiLength = 64
+
+// maxReactionRetries bounds how often adding a reaction is retried after losing a race.
+const maxReactionRetries = 3
+
+// mentionPattern matches an @username token that does not follow a word character,
+// so email addresses are not mistaken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+
+// timelineProjection leaves out what clients never see when listing messages.
+var timelineProjection = bson.M{"revisions": 0, "hiddenFor": 0, "hereIds": 0}
+
 // MessageService provides methods to manage messages.
 type MessageService struct {
 	conversationCollection *mongo.Collection
 	messageCollection      *mongo.Collection
+	userCollection         *mongo.Collection
+	presenceService        *PresenceService
+	editWindow             time.Duration
 }
 
-// NewMessageService creates a new MessageService with the given database.
-func NewMessageService(db *mongo.Database) *MessageService {
+// ReadResult describes the effect of a participant reading a conversation up to a message.
+type ReadResult struct {
+	ConversationId primitive.ObjectID   `json:"conversationId"`
+	UserId         primitive.ObjectID   `json:"userId"`
+	MessageId      primitive.ObjectID   `json:"messageId"`
+	Seq            int64                `json:"seq"`
+	ReadAt         time.Time            `json:"readAt"`
+	Senders        []primitive.ObjectID `json:"-"`
+}
+
+// NewMessageService creates a new MessageService with the given database. The presence
+// service tells who an @here reaches.
+// Messages can be edited for MESSAGE_EDIT_WINDOW (e.g. 15m) after they were sent,
+// or at any time when it is not set.
+func NewMessageService(db *mongo.Database, presenceService *PresenceService) *MessageService {
+	editWindow, err := time.ParseDuration(os.Getenv("MESSAGE_EDIT_WINDOW"))
+	if err != nil || editWindow < 0 {
+		editWindow = 0
+	}
+
 	return &MessageService{
 		conversationCollection: db.Collection("conversation"),
 		messageCollection:      db.Collection("message"),
+		userCollection:         db.Collection("user"),
+		presenceService:        presenceService,
+		editWindow:             editWindow,
 	}
 }
 
-// validateUserInput checks if the message has valid sender and conversation IDs.
-// Returns an error if either ID is missing.
+// validateUserInput checks if the message has valid sender and conversation IDs and some text.
+// Returns an error if either ID is missing or the text is blank.
 func (ms *MessageService) validateUserInput(message model.Message) error {
 	if message.SenderId == primitive.NilObjectID || message.ConversationId == primitive.NilObjectID {
-		return errors.New("ConversationId and SenderId are required")
+		return utils.NewBadRequestError("ConversationId and SenderId are required")
+	}
+	if strings.TrimSpace(message.Message) == "" {
+		return utils.NewBadRequestError("message cannot be empty")
 	}
 	return nil
 }
 
+// EnsureIndexes creates the indexes the message queries rely on.
+// The unique conversationId + seq index guarantees a sequence number is never reused;
+// the conversationId + createdAt index serves date lookups, the threadRootId + seq
+// index lists threads, the deletedAt index lets the purge job find the tombstones
+// whose text is due to go, and the mentions, hereIds and broadcast indexes serve
+// the mentions of a user.
+func (ms *MessageService) EnsureIndexes(ctx context.Context) error {
+	_, err := ms.messageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "conversationId", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "conversationId", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "threadRootId", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"threadRootId": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"deletedAt": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "mentions", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"mentions": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "hereIds", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"hereIds": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "conversationId", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"broadcast": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
 // Create adds a new message to the database if it is valid.
+// The message gets the next sequence number of its conversation; the counter
+// increment and the insert run in one transaction so sequence numbers have no gaps.
+// Every other participant of the conversation gets a receipt in the "sent" status,
+// except in channels.
+// The sender must take part in the conversation and be allowed to post by its
+// moderation rules; if they may post later, the error says when.
+// The @username mentions of participants are resolved to their IDs, and @here and
+// @all are allowed in groups and channels as their moderation rules say.
+// A message with a ParentId is a reply in the thread of that message, or of the
+// thread it is itself a reply in; the summary of the thread root is updated with it.
 // Returns the created message or an error if the operation fails.
 func (ms *MessageService) Create(message model.Message) (*model.Message, error) {
 
@@ -44,14 +143,1065 @@ func (ms *MessageService) Create(message model.Message) (*model.Message, error)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	message.ID = primitive.NewObjectID()
-	message.CreatedAt = time.Now()
-	message.UpdatedAt = time.Now()
+	session, err := ms.messageCollection.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+
+		var current model.Conversation
+		err := ms.conversationCollection.FindOne(sc, bson.M{"_id": message.ConversationId}).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, utils.NewNotFoundError("Conversation not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPosting(&current, message.SenderId, now); err != nil {
+			return nil, err
+		}
+		if err := ms.resolveMentions(sc, &current, &message); err != nil {
+			return nil, err
+		}
+
+		var root *model.Message
+		if message.ParentId != nil {
+			if root, err = ms.threadRoot(sc, message.ConversationId, *message.ParentId); err != nil {
+				return nil, err
+			}
+			message.ThreadRootId = &root.ID
+		}
+
+		// updatedAt is left alone: it guards membership changes, which a new
+		// message should not make retry. lastPostedAt is outside the participants
+		// for the same reason, so those changes cannot roll it back.
+		var conversation model.Conversation
+		err = ms.conversationCollection.FindOneAndUpdate(sc,
+			bson.M{"_id": message.ConversationId},
+			bson.M{
+				"$inc": bson.M{"lastSeq": 1},
+				"$set": bson.M{"lastMessageAt": now, "lastPostedAt." + message.SenderId.Hex(): now},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&conversation)
+		if err != nil {
+			return nil, err
+		}
+
+		// Channels are too large to track every recipient of every message;
+		// their members only have a read pointer
+		message.Receipts = []model.Receipt{}
+		if conversation.Type != model.ConversationTypeChannel {
+			for _, userID := range conversation.ParticipantIDs() {
+				if userID != message.SenderId {
+					message.Receipts = append(message.Receipts, model.Receipt{UserId: userID, Status: model.MessageStatusSent})
+				}
+			}
+		}
+
+		message.ID = primitive.NewObjectID()
+		message.Seq = conversation.LastSeq
+		message.CreatedAt = now
+		message.UpdatedAt = now
+
+		if _, err := ms.messageCollection.InsertOne(sc, message); err != nil {
+			return nil, err
+		}
+		if root != nil {
+			return nil, ms.addReply(sc, root, message.SenderId, now)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// resolveMentions fills in the participants mentioned by a new message of the conversation,
+// and for an @here the participants online at that moment. Mentioning someone who is
+// not a participant is an error; the sender mentioning themselves is ignored.
+func (ms *MessageService) resolveMentions(ctx context.Context, conversation *model.Conversation, message *model.Message) error {
+	message.Mentions, message.Broadcast, message.HereIds = nil, "", nil
+
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(message.Message, -1) {
+		// Punctuation ending a sentence is not part of the name
+		name := strings.TrimRight(match[1], ".-")
+		switch {
+		case name == "":
+		case name == model.MentionAll || name == model.MentionHere:
+			// Both participants of a direct conversation see everything anyway
+			if conversation.Type != model.ConversationTypeDirect && message.Broadcast != model.MentionAll {
+				message.Broadcast = name
+			}
+		default:
+			usernames = append(usernames, name)
+		}
+	}
+
+	if message.Broadcast != "" {
+		switch conversation.Moderation.BroadcastMentions {
+		case model.BroadcastMentionsDisabled:
+			return utils.NewForbiddenError("@here and @all are disabled in this conversation")
+		case model.BroadcastMentionsAdmins:
+			if !conversation.IsAdmin(message.SenderId) {
+				return utils.NewForbiddenError("only owners and admins can mention @here and @all in this conversation")
+			}
+		}
+	}
+	if message.Broadcast == model.MentionHere {
+		// @here reaches the participants online now, whoever comes online later
+		online, err := ms.presenceService.Online(ctx, conversation.ParticipantIDs())
+		if err != nil {
+			return err
+		}
+		for _, userID := range conversation.ParticipantIDs() {
+			if online[userID] && userID != message.SenderId {
+				message.HereIds = append(message.HereIds, userID)
+			}
+		}
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "username": 1})
+	cursor, err := ms.userCollection.Find(ctx, bson.M{"username": bson.M{"$in": usernames}}, opts)
+	if err != nil {
+		return err
+	}
+	var users []model.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	participants := make(map[string]primitive.ObjectID, len(users))
+	for _, user := range users {
+		if conversation.HasParticipant(user.ID) {
+			participants[user.Username] = user.ID
+		}
+	}
+	var unknown []string
+	for _, name := range usernames {
+		userID, ok := participants[name]
+		switch {
+		case !ok:
+			unknown = append(unknown, "@"+name)
+		case userID != message.SenderId && !message.MentionsUser(userID):
+			message.Mentions = append(message.Mentions, userID)
+		}
+	}
+	if len(unknown) > 0 {
+		return utils.NewBadRequestError(fmt.Sprintf("not participants of this conversation: %s", strings.Join(unknown, ", ")))
+	}
+	return nil
+}
+
+// threadRoot returns the root of the thread a reply to the parent message goes to:
+// the parent itself, or the root of the thread the parent is a reply in.
+func (ms *MessageService) threadRoot(ctx context.Context, conversationID, parentID primitive.ObjectID) (*model.Message, error) {
+	root, err := ms.resolveThreadRoot(ctx, conversationID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if root.Deleted() {
+		return nil, utils.NewBadRequestError("cannot reply to a deleted message")
+	}
+	return root, nil
+}
+
+// addReply updates the thread summary of a root for a new reply by the sender.
+// Repliers follow the thread, and so does the root's sender when the first reply comes in.
+func (ms *MessageService) addReply(ctx context.Context, root *model.Message, senderID primitive.ObjectID, at time.Time) error {
+	followers := []primitive.ObjectID{senderID}
+	if root.Thread == nil || root.Thread.ReplyCount == 0 {
+		followers = append(followers, root.SenderId)
+	}
+
+	_, err := ms.messageCollection.UpdateOne(ctx,
+		bson.M{"_id": root.ID},
+		bson.M{
+			"$inc": bson.M{"thread.replyCount": 1},
+			"$set": bson.M{"thread.lastReplyAt": at},
+			"$addToSet": bson.M{
+				"thread.participants": bson.M{"$each": []primitive.ObjectID{root.SenderId, senderID}},
+				"thread.followers":    bson.M{"$each": followers},
+			},
+		},
+	)
+	return err
+}
+
+// checkPosting applies the moderation rules of a conversation to a new message of the sender.
+// Owners and admins are exempt.
+func checkPosting(conversation *model.Conversation, senderID primitive.ObjectID, now time.Time) error {
+	sender := conversation.Participant(senderID)
+	if sender == nil {
+		return utils.NewForbiddenError("you are not a participant of this conversation")
+	}
+	if conversation.IsAdmin(senderID) {
+		return nil
+	}
+
+	if conversation.Moderation.AnnouncementsOnly {
+		return utils.NewForbiddenError("only owners and admins can post in this conversation")
+	}
+	if sender.MutedUntil != nil && sender.MutedUntil.After(now) {
+		return utils.NewForbiddenError("you have been muted in this conversation").RetryAfter(*sender.MutedUntil)
+	}
+	lastPostedAt := conversation.LastPostedBy(senderID)
+	if slowMode := time.Duration(conversation.Moderation.SlowModeSeconds) * time.Second; slowMode > 0 && !lastPostedAt.IsZero() {
+		if next := lastPostedAt.Add(slowMode); next.After(now) {
+			return utils.NewTooManyRequestsError("slow mode is on, wait before posting again").RetryAfter(next)
+		}
+	}
+	return nil
+}
+
+// ListAfter returns up to limit messages of a conversation with a sequence number
+// greater than afterSeq, oldest first, as seen by the user: messages deleted for
+// everyone are tombstones, and those the user deleted for themselves are left out.
+func (ms *MessageService) ListAfter(ctx context.Context, conversationID, userID primitive.ObjectID, afterSeq int64, limit int64) ([]model.Message, error) {
+	filter := bson.M{
+		"conversationId": conversationID,
+		"seq":            bson.M{"$gt": afterSeq},
+		"hiddenFor":      bson.M{"$ne": userID},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(limit).SetProjection(timelineProjection)
+
+	cursor, err := ms.messageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Redact()
+	}
+	return messages, nil
+}
+
+// History page sizes.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+)
+
+// HistoryQuery selects a page of a conversation's history. At most one of Before,
+// After, Around (sequence numbers) and At is used, in that order; with none of
+// them, the page holds the latest messages. At jumps to the first message sent at
+// or after that time, and returns the messages around it.
+type HistoryQuery struct {
+	Before int64
+	After  int64
+	Around int64
+	At     time.Time
+	Limit  int
+}
+
+// MessagePage is a page of history, oldest message first. HasOlder and HasNewer
+// tell whether there are messages before and after the page.
+type MessagePage struct {
+	Messages []model.Message `json:"messages"`
+	HasOlder bool            `json:"hasOlder"`
+	HasNewer bool            `json:"hasNewer"`
+}
+
+// ParseHistoryTime parses the target of a jump to date: an RFC 3339 time, or a
+// date (2006-01-02) standing for midnight in loc.
+func ParseHistoryTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date (2006-01-02) nor an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+// History returns a page of the main timeline of a conversation, as seen by the user.
+// Thread replies are not part of it.
+func (ms *MessageService) History(ctx context.Context, conversation *model.Conversation, userID primitive.ObjectID, query HistoryQuery) (*MessagePage, error) {
+	scope := bson.M{
+		"conversationId": conversation.ID,
+		"threadRootId":   bson.M{"$exists": false},
+		"hiddenFor":      bson.M{"$ne": userID},
+	}
+	return ms.page(ctx, scope, conversation.LastSeq, query)
+}
+
+// ThreadPage is a page of the replies in a thread, oldest first, with its root and
+// whether the user follows it.
+type ThreadPage struct {
+	Root      *model.Message `json:"root"`
+	Following bool           `json:"following"`
+	MessagePage
+}
+
+// Thread returns a page of the replies in the thread of a message of the conversation,
+// as seen by the user. The message may also be one of the replies.
+func (ms *MessageService) Thread(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID, query HistoryQuery) (*ThreadPage, error) {
+	root, err := ms.resolveThreadRoot(ctx, conversation.ID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := bson.M{
+		"conversationId": conversation.ID,
+		"threadRootId":   root.ID,
+		"hiddenFor":      bson.M{"$ne": userID},
+	}
+	page, err := ms.page(ctx, scope, conversation.LastSeq, query)
+	if err != nil {
+		return nil, err
+	}
+
+	following := root.Thread.Follows(userID)
+	root.Redact()
+	return &ThreadPage{Root: root, Following: following, MessagePage: *page}, nil
+}
+
+// FollowThread makes the user follow or stop following the thread of a message of
+// the conversation. Followers are alerted of new replies.
+func (ms *MessageService) FollowThread(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID, follow bool) error {
+	root, err := ms.resolveThreadRoot(ctx, conversation.ID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if !follow {
+		_, err = ms.messageCollection.UpdateOne(ctx, bson.M{"_id": root.ID}, bson.M{"$pull": bson.M{"thread.followers": userID}})
+		return err
+	}
+
+	// Following a message nobody replied to yet starts its thread
+	result, err := ms.messageCollection.UpdateOne(ctx,
+		bson.M{"_id": root.ID, "thread": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"thread": model.Thread{
+			Participants: []primitive.ObjectID{root.SenderId},
+			Followers:    []primitive.ObjectID{userID},
+		}}},
+	)
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+	_, err = ms.messageCollection.UpdateOne(ctx, bson.M{"_id": root.ID}, bson.M{"$addToSet": bson.M{"thread.followers": userID}})
+	return err
+}
+
+// ThreadFollowers returns the users following the thread of a root message.
+func (ms *MessageService) ThreadFollowers(ctx context.Context, rootID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var root model.Message
+	opts := options.FindOne().SetProjection(bson.M{"thread.followers": 1})
+	if err := ms.messageCollection.FindOne(ctx, bson.M{"_id": rootID}, opts).Decode(&root); err != nil {
+		return nil, err
+	}
+	if root.Thread == nil {
+		return nil, nil
+	}
+	return root.Thread.Followers, nil
+}
+
+// resolveThreadRoot loads the root of the thread of a message of the conversation,
+// which is the message itself unless it is a reply.
+func (ms *MessageService) resolveThreadRoot(ctx context.Context, conversationID, messageID primitive.ObjectID) (*model.Message, error) {
+	message, err := ms.findInConversation(ctx, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ThreadRootId == nil {
+		return message, nil
+	}
+	return ms.findInConversation(ctx, conversationID, *message.ThreadRootId)
+}
+
+// page returns a page of the messages matching scope, the newest of which has a
+// sequence number of at most lastSeq.
+func (ms *MessageService) page(ctx context.Context, scope bson.M, lastSeq int64, query HistoryQuery) (*MessagePage, error) {
+	limit := int64(query.Limit)
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	switch {
+	case query.Before > 0:
+		older, hasOlder, err := ms.listRange(ctx, scope, bson.M{"$lt": query.Before}, false, limit)
+		if err != nil {
+			return nil, err
+		}
+		return &MessagePage{Messages: older, HasOlder: hasOlder, HasNewer: query.Before <= lastSeq}, nil
+
+	case query.After > 0:
+		newer, hasNewer, err := ms.listRange(ctx, scope, bson.M{"$gt": query.After}, true, limit)
+		if err != nil {
+			return nil, err
+		}
+		return &MessagePage{Messages: newer, HasOlder: true, HasNewer: hasNewer}, nil
+
+	case query.Around > 0:
+		return ms.around(ctx, scope, query.Around, limit)
+
+	case !query.At.IsZero():
+		// Jump to the first message sent at or after the date
+		filter := bson.M{"createdAt": bson.M{"$gte": query.At}}
+		for key, value := range scope {
+			filter[key] = value
+		}
+		var first model.Message
+		opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetProjection(bson.M{"seq": 1})
+		err := ms.messageCollection.FindOne(ctx, filter, opts).Decode(&first)
+		if err == nil {
+			return ms.around(ctx, scope, first.Seq, limit)
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		// Nothing was sent since, so the latest messages are the closest
+		fallthrough
+
+	default:
+		latest, hasOlder, err := ms.listRange(ctx, scope, bson.M{"$gt": 0}, false, limit)
+		if err != nil {
+			return nil, err
+		}
+		return &MessagePage{Messages: latest, HasOlder: hasOlder}, nil
+	}
+}
+
+// around returns up to limit messages matching scope, centered on the message with
+// the given sequence number.
+func (ms *MessageService) around(ctx context.Context, scope bson.M, seq, limit int64) (*MessagePage, error) {
+	newerLimit := limit / 2
+	older, hasOlder, err := ms.listRange(ctx, scope, bson.M{"$lte": seq}, false, limit-newerLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: older, HasOlder: hasOlder}
+	if newerLimit == 0 {
+		page.HasNewer = true
+		return page, nil
+	}
+
+	newer, hasNewer, err := ms.listRange(ctx, scope, bson.M{"$gt": seq}, true, newerLimit)
+	if err != nil {
+		return nil, err
+	}
+	page.Messages = append(page.Messages, newer...)
+	page.HasNewer = hasNewer
+	return page, nil
+}
+
+// listRange returns up to limit messages matching scope whose sequence number
+// matches seqFilter, closest to the filter bound first when reading, but always
+// oldest first in the result. It also reports whether more messages matched.
+func (ms *MessageService) listRange(ctx context.Context, scope bson.M, seqFilter bson.M, ascending bool, limit int64) ([]model.Message, bool, error) {
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+	filter := bson.M{"seq": seqFilter}
+	for key, value := range scope {
+		filter[key] = value
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: direction}}).SetLimit(limit + 1).SetProjection(timelineProjection)
+
+	cursor, err := ms.messageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	messages := []model.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, false, err
+	}
+
+	more := int64(len(messages)) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	for i := range messages {
+		messages[i].Redact()
+	}
+	if !ascending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, more, nil
+}
+
+// MarkDelivered moves the user's receipt on a message from "sent" to "delivered".
+// Returns the updated receipt, or nil if the message was already delivered to the user.
+func (ms *MessageService) MarkDelivered(ctx context.Context, messageID, userID primitive.ObjectID) (*model.Receipt, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":      messageID,
+		"receipts": bson.M{"$elemMatch": bson.M{"userId": userID, "status": model.MessageStatusSent}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"receipts.$.status":      model.MessageStatusDelivered,
+			"receipts.$.deliveredAt": now,
+		},
+	}
+
+	result, err := ms.messageCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, nil
+	}
+	return &model.Receipt{UserId: userID, Status: model.MessageStatusDelivered, DeliveredAt: now}, nil
+}
+
+// MarkRead records that the user has read the conversation up to and including the given message.
+// The user's last-read pointer on the conversation only ever moves forward, and every
+// earlier message from other senders gets a "read" receipt for the user.
+// Returns the read result, or nil if the pointer was already at or past the message.
+func (ms *MessageService) MarkRead(ctx context.Context, conversationID, userID, messageID primitive.ObjectID) (*ReadResult, error) {
+	var message model.Message
+	err := ms.messageCollection.FindOne(ctx, bson.M{"_id": messageID, "conversationId": conversationID}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.NewNotFoundError("Message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	moved, err := ms.moveReadPointer(ctx, conversationID, userID, &message, now)
+	if err != nil || !moved {
+		return nil, err
+	}
+
+	// Mark every message up to the pointer as read by the user
+	messageFilter := bson.M{
+		"conversationId": conversationID,
+		"seq":            bson.M{"$lte": message.Seq},
+		"receipts": bson.M{"$elemMatch": bson.M{
+			"userId": userID,
+			"status": bson.M{"$ne": model.MessageStatusRead},
+		}},
+	}
+
+	senders, err := ms.messageCollection.Distinct(ctx, "senderId", messageFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"receipts.$[r].status": model.MessageStatusRead,
+			"receipts.$[r].readAt": now,
+		},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"r.userId": userID, "r.status": bson.M{"$ne": model.MessageStatusRead}}},
+	})
+	if _, err := ms.messageCollection.UpdateMany(ctx, messageFilter, update, opts); err != nil {
+		return nil, err
+	}
+
+	result := &ReadResult{
+		ConversationId: conversationID,
+		UserId:         userID,
+		MessageId:      messageID,
+		Seq:            message.Seq,
+		ReadAt:         now,
+	}
+	for _, sender := range senders {
+		if id, ok := sender.(primitive.ObjectID); ok {
+			result.Senders = append(result.Senders, id)
+		}
+	}
+	return result, nil
+}
+
+// moveReadPointer advances the user's last-read pointer on the conversation to the message.
+// Returns false if the pointer was already at or past the message.
+func (ms *MessageService) moveReadPointer(ctx context.Context, conversationID, userID primitive.ObjectID, message *model.Message, readAt time.Time) (bool, error) {
+	// Move an existing pointer forward
+	filter := bson.M{
+		"_id":      conversationID,
+		"lastRead": bson.M{"$elemMatch": bson.M{"userId": userID, "seq": bson.M{"$lt": message.Seq}}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"lastRead.$.messageId": message.ID,
+			"lastRead.$.seq":       message.Seq,
+			"lastRead.$.readAt":    readAt,
+		},
+	}
+	result, err := ms.conversationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount > 0 {
+		return true, nil
+	}
+
+	// Or create the pointer if the user has never read the conversation
+	filter = bson.M{
+		"_id":             conversationID,
+		"lastRead.userId": bson.M{"$ne": userID},
+	}
+	update = bson.M{
+		"$push": bson.M{
+			"lastRead": model.ReadPointer{UserId: userID, MessageId: message.ID, Seq: message.Seq, ReadAt: readAt},
+		},
+	}
+	result, err = ms.conversationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// EditHistory is the current text of a message and its prior versions, oldest first.
+type EditHistory struct {
+	MessageId primitive.ObjectID `json:"messageId"`
+	Message   string             `json:"message"`
+	EditedAt  *time.Time         `json:"editedAt,omitempty"`
+	Revisions []model.Revision   `json:"revisions"`
+}
+
+// Edit replaces the text of a message of the conversation. Only its sender can edit
+// it, within the edit window; the previous text is kept as a revision, and the
+// mentions are resolved again from the new text.
+// Returns the edited message and the participants the edit mentions who were not
+// mentioned before, or an error if the operation fails.
+func (ms *MessageService) Edit(ctx context.Context, conversation *model.Conversation, messageID, editorID primitive.ObjectID, text string) (*model.Message, []primitive.ObjectID, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil, utils.NewBadRequestError("message cannot be empty")
+	}
 
-	_, err := ms.messageCollection.InsertOne(ctx, message)
+	message, err := ms.findInConversation(ctx, conversation.ID, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message.SenderId != editorID {
+		return nil, nil, utils.NewForbiddenError("only the sender can edit a message")
+	}
+	if message.Deleted() {
+		return nil, nil, utils.NewBadRequestError("a deleted message cannot be edited")
+	}
+	now := time.Now()
+	if ms.editWindow > 0 && now.After(message.CreatedAt.Add(ms.editWindow)) {
+		return nil, nil, utils.NewForbiddenError("this message can no longer be edited")
+	}
+	if message.Message == text {
+		return message, nil, nil
+	}
+
+	revision := model.Revision{Message: message.Message, CreatedAt: message.CreatedAt}
+	if message.EditedAt != nil {
+		revision.CreatedAt = *message.EditedAt
+	}
+
+	// The mentions follow the new text
+	mentions := model.Message{SenderId: message.SenderId, Message: text}
+	if err := ms.resolveMentions(ctx, conversation, &mentions); err != nil {
+		return nil, nil, err
+	}
+	set := bson.M{"message": text, "editedAt": now, "updatedAt": now}
+	unset := bson.M{}
+	if len(mentions.Mentions) > 0 {
+		set["mentions"] = mentions.Mentions
+	} else {
+		unset["mentions"] = ""
+	}
+	if mentions.Broadcast != "" {
+		set["broadcast"] = mentions.Broadcast
+	} else {
+		unset["broadcast"] = ""
+	}
+	if len(mentions.HereIds) > 0 {
+		set["hereIds"] = mentions.HereIds
+	} else {
+		unset["hereIds"] = ""
+	}
+	update := bson.M{"$push": bson.M{"revisions": revision}, "$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// Matching the text read above makes concurrent edits fail instead of losing a revision
+	var edited model.Message
+	err = ms.messageCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": message.ID, "message": message.Message, "deletedAt": bson.M{"$exists": false}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&edited)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, utils.NewConflictError("the message was edited at the same time, try again")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mentioned []primitive.ObjectID
+	for _, userID := range conversation.ParticipantIDs() {
+		if userID == edited.SenderId || edited.HiddenFrom(userID) {
+			continue
+		}
+		before := message.BroadcastsTo(userID) || message.MentionsUser(userID)
+		after := edited.BroadcastsTo(userID) || edited.MentionsUser(userID)
+		if after && !before {
+			mentioned = append(mentioned, userID)
+		}
+	}
+	return &edited, mentioned, nil
+}
+
+// EditHistory returns the prior versions of a message of the conversation.
+// Only owners and admins of the conversation can see them.
+func (ms *MessageService) EditHistory(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID) (*EditHistory, error) {
+	if !conversation.IsAdmin(userID) {
+		return nil, utils.NewForbiddenError("only owners and admins can view the edit history")
+	}
+
+	message, err := ms.findInConversation(ctx, conversation.ID, messageID)
 	if err != nil {
 		return nil, err
 	}
 
+	history := &EditHistory{
+		MessageId: message.ID,
+		Message:   message.Message,
+		EditedAt:  message.EditedAt,
+		Revisions: message.Revisions,
+	}
+	if history.Revisions == nil {
+		history.Revisions = []model.Revision{}
+	}
+	return history, nil
+}
+
+// DeleteForMe hides a message of the conversation from the user, leaving it
+// untouched for everyone else.
+func (ms *MessageService) DeleteForMe(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID) (*model.Message, error) {
+	message, err := ms.findInConversation(ctx, conversation.ID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ms.messageCollection.UpdateOne(ctx,
+		bson.M{"_id": message.ID},
+		bson.M{"$addToSet": bson.M{"hiddenFor": userID}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.Redact()
+	return message, nil
+}
+
+// DeleteForEveryone turns a message of the conversation into a tombstone: it keeps
+// its sequence number, but its text is no longer shown to anyone and is purged after
+// the retention delay. The sender and the owners and admins of the conversation can
+// delete a message for everyone; deleting a tombstone again changes nothing.
+func (ms *MessageService) DeleteForEveryone(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID) (*model.Message, error) {
+	message, err := ms.findInConversation(ctx, conversation.ID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderId != userID && !conversation.IsAdmin(userID) {
+		return nil, utils.NewForbiddenError("only the sender or an admin can delete a message for everyone")
+	}
+
+	if !message.Deleted() {
+		now := time.Now()
+		err = ms.messageCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": message.ID},
+			[]bson.M{{"$set": bson.M{
+				"deletedAt": bson.M{"$ifNull": bson.A{"$deletedAt", now}},
+				"deletedBy": bson.M{"$ifNull": bson.A{"$deletedBy", userID}},
+				"updatedAt": now,
+			}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(message)
+		if err != nil {
+			return nil, err
+		}
+	}
+	message.Redact()
+	return message, nil
+}
+
+// PurgeDeleted erases the text and revisions of the messages deleted for everyone
+// before the given time. Their tombstones stay. Returns how many messages were purged.
+func (ms *MessageService) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result, err := ms.messageCollection.UpdateMany(ctx,
+		bson.M{"deletedAt": bson.M{"$lte": deletedBefore}, "purgedAt": bson.M{"$exists": false}},
+		bson.M{
+			"$set":   bson.M{"message": "", "purgedAt": time.Now()},
+			"$unset": bson.M{"revisions": ""},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// MentionPage is a page of the messages mentioning a user, newest first.
+// NextCursor is empty on the last page.
+type MentionPage struct {
+	Messages   []model.Message `json:"messages"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// Mentions returns the messages mentioning the user by name, or with @here or @all,
+// in the conversations they take part in, newest first. Messages sent before the user
+// joined a conversation are left out. cursor is the NextCursor of the previous page.
+func (ms *MessageService) Mentions(ctx context.Context, userID primitive.ObjectID, cursor string, limit int) (*MentionPage, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	// Only the user's own participant entry is needed, for its joinedAt
+	opts := options.Find().SetProjection(bson.M{"participants.$": 1})
+	results, err := ms.conversationCollection.Find(ctx, bson.M{"participants.userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var conversations []model.Conversation
+	if err := results.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+	sinceJoined := make(bson.A, 0, len(conversations))
+	for _, conversation := range conversations {
+		participant := conversation.Participant(userID)
+		if participant == nil {
+			continue
+		}
+		sinceJoined = append(sinceJoined, bson.M{
+			"conversationId": conversation.ID,
+			"createdAt":      bson.M{"$gte": participant.JoinedAt},
+		})
+	}
+	if len(sinceJoined) == 0 {
+		return &MentionPage{Messages: []model.Message{}}, nil
+	}
+
+	filter := bson.M{
+		"$and": bson.A{
+			bson.M{"$or": sinceJoined},
+			bson.M{"$or": bson.A{
+				bson.M{"mentions": userID},
+				bson.M{"hereIds": userID},
+				bson.M{"broadcast": model.MentionAll},
+			}},
+		},
+		"senderId":  bson.M{"$ne": userID},
+		"hiddenFor": bson.M{"$ne": userID},
+		"deletedAt": bson.M{"$exists": false},
+	}
+	if cursor != "" {
+		before, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return nil, utils.NewBadRequestError("invalid cursor")
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	opts = options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit) + 1).
+		SetProjection(timelineProjection)
+	results, err = ms.messageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{}
+	if err := results.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	page := &MentionPage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = page.Messages[limit-1].ID.Hex()
+	}
+	return page, nil
+}
+
+// ReactionChange is the effect of a user adding or removing a reaction: the emoji,
+// how many users now reacted with it, and whether anything changed.
+type ReactionChange struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	UserId         primitive.ObjectID `json:"userId"`
+	Emoji          string             `json:"emoji"`
+	Count          int                `json:"count"`
+	Changed        bool               `json:"-"`
+}
+
+// AddReaction reacts to a message of the conversation with an emoji on behalf of the
+// user. Reacting twice with the same emoji changes nothing. Every update is a single
+// atomic operation on the message, so concurrent reactions are all counted, and a
+// message has at most maxReactionsPerMessage different emojis.
+func (ms *MessageService) AddReaction(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID, emoji string) (*ReactionChange, error) {
+	if emoji == "" || len(emoji) > maxEmojiLength {
+		return nil, utils.NewBadRequestError(fmt.Sprintf("emoji must be between 1 and %d bytes long", maxEmojiLength))
+	}
+	change := &ReactionChange{ConversationId: conversation.ID, MessageId: messageID, UserId: userID, Emoji: emoji}
+	live := bson.M{"_id": messageID, "conversationId": conversation.ID, "deletedAt": bson.M{"$exists": false}}
+
+	for attempt := 0; attempt < maxReactionRetries; attempt++ {
+		// Join the users who already reacted with the emoji
+		filter := bson.M{"reactions": bson.M{"$elemMatch": bson.M{"emoji": emoji, "userIds": bson.M{"$ne": userID}}}}
+		for key, value := range live {
+			filter[key] = value
+		}
+		message, err := ms.updateReactions(ctx, filter, bson.M{
+			"$addToSet": bson.M{"reactions.$.userIds": userID},
+			"$inc":      bson.M{"reactions.$.count": 1},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if message != nil {
+			change.Count, change.Changed = message.Reaction(emoji).Count, true
+			return change, nil
+		}
+
+		// Or be the first, if there is room for another emoji
+		filter = bson.M{
+			"reactions.emoji": bson.M{"$ne": emoji},
+			fmt.Sprintf("reactions.%d", maxReactionsPerMessage-1): bson.M{"$exists": false},
+		}
+		for key, value := range live {
+			filter[key] = value
+		}
+		message, err = ms.updateReactions(ctx, filter, bson.M{
+			"$push": bson.M{"reactions": model.Reaction{Emoji: emoji, Count: 1, UserIds: []primitive.ObjectID{userID}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if message != nil {
+			change.Count, change.Changed = 1, true
+			return change, nil
+		}
+
+		// Neither matched: find out why
+		current, err := ms.findInConversation(ctx, conversation.ID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if current.Deleted() {
+			return nil, utils.NewBadRequestError("cannot react to a deleted message")
+		}
+		if reaction := current.Reaction(emoji); reaction != nil {
+			for _, id := range reaction.UserIds {
+				if id == userID {
+					change.Count = reaction.Count
+					return change, nil
+				}
+			}
+			// Someone else added the emoji in the meantime
+			continue
+		}
+		if len(current.Reactions) >= maxReactionsPerMessage {
+			return nil, utils.NewBadRequestError(fmt.Sprintf("a message can have at most %d different reactions", maxReactionsPerMessage))
+		}
+	}
+	return nil, utils.NewConflictError("the message is being reacted to by many users, try again")
+}
+
+// RemoveReaction withdraws the user's reaction with an emoji from a message of the
+// conversation. The emoji goes away with its last user.
+func (ms *MessageService) RemoveReaction(ctx context.Context, conversation *model.Conversation, messageID, userID primitive.ObjectID, emoji string) (*ReactionChange, error) {
+	change := &ReactionChange{ConversationId: conversation.ID, MessageId: messageID, UserId: userID, Emoji: emoji}
+
+	message, err := ms.updateReactions(ctx,
+		bson.M{
+			"_id":            messageID,
+			"conversationId": conversation.ID,
+			"reactions":      bson.M{"$elemMatch": bson.M{"emoji": emoji, "userIds": userID}},
+		},
+		bson.M{
+			"$pull": bson.M{"reactions.$.userIds": userID},
+			"$inc":  bson.M{"reactions.$.count": -1},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		// The user had not reacted with the emoji, if the message exists at all
+		current, err := ms.findInConversation(ctx, conversation.ID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if reaction := current.Reaction(emoji); reaction != nil {
+			change.Count = reaction.Count
+		}
+		return change, nil
+	}
+
+	change.Changed = true
+	if reaction := message.Reaction(emoji); reaction != nil && reaction.Count > 0 {
+		change.Count = reaction.Count
+		return change, nil
+	}
+
+	// Only remove the emoji if nobody reacted with it again in the meantime
+	_, err = ms.messageCollection.UpdateOne(ctx,
+		bson.M{"_id": messageID},
+		bson.M{"$pull": bson.M{"reactions": bson.M{"emoji": emoji, "count": bson.M{"$lte": 0}}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// updateReactions applies an update to the reactions of the message matching filter
+// and returns them as they are after it, or nil if no message matched.
+func (ms *MessageService) updateReactions(ctx context.Context, filter, update bson.M) (*model.Message, error) {
+	var message model.Message
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"reactions": 1})
+	err := ms.messageCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// findInConversation loads a message, which must belong to the conversation.
+func (ms *MessageService) findInConversation(ctx context.Context, conversationID, messageID primitive.ObjectID) (*model.Message, error) {
+	var message model.Message
+	err := ms.messageCollection.FindOne(ctx, bson.M{"_id": messageID, "conversationId": conversationID}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, utils.NewNotFoundError("Message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &message, nil
 }
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+func TestCheckPostingSlowMode(t *testing.T) {
+	now := time.Now()
+	memberID := primitive.NewObjectID()
+	conversation := &model.Conversation{
+		Participants: []model.Participant{{UserId: memberID, Role: model.RoleMember}},
+		Moderation:   model.Moderation{SlowModeSeconds: 30},
+	}
+
+	if err := checkPosting(conversation, memberID, now); err != nil {
+		t.Fatalf("first post: %v", err)
+	}
+
+	conversation.LastPostedAt = map[string]time.Time{memberID.Hex(): now.Add(-10 * time.Second)}
+	if err := checkPosting(conversation, memberID, now); err == nil {
+		t.Fatal("posting again within the slow mode interval was allowed")
+	}
+
+	conversation.LastPostedAt[memberID.Hex()] = now.Add(-31 * time.Second)
+	if err := checkPosting(conversation, memberID, now); err != nil {
+		t.Fatalf("posting after the slow mode interval: %v", err)
+	}
+}
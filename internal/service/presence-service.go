@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"simple-chat-app/internal/model"
+)
+
+// PresenceService tracks which users are online and when they were last seen.
+// A user is online while at least one of their devices is connected to the gateway,
+// on any replica: the connections are kept in the PresenceStore.
+type PresenceService struct {
+	conversationCollection *mongo.Collection
+	userCollection         *mongo.Collection
+	store                  PresenceStore
+}
+
+// NewPresenceService creates a new PresenceService with the given database and connection store.
+func NewPresenceService(db *mongo.Database, store PresenceStore) *PresenceService {
+	return &PresenceService{
+		conversationCollection: db.Collection("conversation"),
+		userCollection:         db.Collection("user"),
+		store:                  store,
+	}
+}
+
+// Connected records a new connection of the user.
+// Returns true if it is the user's first connection, i.e. the user just came online.
+func (ps *PresenceService) Connected(ctx context.Context, userID primitive.ObjectID, connectionID string) (bool, error) {
+	return ps.store.Add(ctx, userID, connectionID)
+}
+
+// Refresh keeps the connections of a user that are still open on this replica live.
+func (ps *PresenceService) Refresh(ctx context.Context, userID primitive.ObjectID, connectionIDs []string) error {
+	return ps.store.Refresh(ctx, userID, connectionIDs)
+}
+
+// Disconnected records a dropped connection of the user. When it was the user's last
+// connection, lastSeenAt is saved on the user and the returned presence is offline.
+// Returns nil if the user still has other connections.
+func (ps *PresenceService) Disconnected(ctx context.Context, userID primitive.ObjectID, connectionID string) (*model.Presence, error) {
+	last, err := ps.store.Remove(ctx, userID, connectionID)
+	if err != nil || !last {
+		return nil, err
+	}
+
+	lastSeenAt := time.Now()
+	presence := &model.Presence{UserId: userID, Online: false, LastSeenAt: &lastSeenAt}
+
+	update := bson.M{"$set": bson.M{"lastSeenAt": lastSeenAt}}
+	if _, err := ps.userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		return presence, err
+	}
+	return presence, nil
+}
+
+// Online reports which of the given users have at least one open connection.
+func (ps *PresenceService) Online(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	return ps.store.Online(ctx, userIDs)
+}
+
+// Get returns the presence of each of the given users, in the same order.
+// Unknown users are reported as offline without a lastSeenAt.
+func (ps *PresenceService) Get(ctx context.Context, userIDs []primitive.ObjectID) ([]model.Presence, error) {
+	opts := options.Find().SetProjection(bson.M{"lastSeenAt": 1})
+	cursor, err := ps.userCollection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []model.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	online, err := ps.store.Online(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastSeen := make(map[primitive.ObjectID]time.Time, len(users))
+	for _, user := range users {
+		lastSeen[user.ID] = user.LastSeenAt
+	}
+
+	presences := make([]model.Presence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		presence := model.Presence{UserId: userID, Online: online[userID]}
+		if t, ok := lastSeen[userID]; ok && !t.IsZero() {
+			presence.LastSeenAt = &t
+		}
+		presences = append(presences, presence)
+	}
+	return presences, nil
+}
+
+// Contacts returns the users who share at least one direct conversation or group with
+// the given user. They are the audience of the user's presence events; channels are
+// left out, as they can be too large to announce to.
+func (ps *PresenceService) Contacts(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	filter := bson.M{
+		"participants.userId": userID,
+		"type":                bson.M{"$ne": model.ConversationTypeChannel},
+	}
+
+	cursor, err := ps.conversationCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversations []model.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var contacts []primitive.ObjectID
+	for _, conversation := range conversations {
+		for _, id := range conversation.ParticipantIDs() {
+			if id != userID && !seen[id] {
+				seen[id] = true
+				contacts = append(contacts, id)
+			}
+		}
+	}
+	return contacts, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PresenceTTL is how long a connection counts as live without being refreshed. The
+// gateway refreshes its open connections well within it, so only the connections
+// of a replica that went away without cleaning up expire.
+const PresenceTTL = 90 * time.Second
+
+// PresenceStore keeps the open connections of each user, across every replica.
+type PresenceStore interface {
+	// Add records a connection and reports whether it is the user's only live one.
+	Add(ctx context.Context, userID primitive.ObjectID, connectionID string) (first bool, err error)
+	// Remove drops a connection and reports whether the user has no live one left.
+	Remove(ctx context.Context, userID primitive.ObjectID, connectionID string) (last bool, err error)
+	// Refresh keeps the given connections of a user live for another PresenceTTL.
+	Refresh(ctx context.Context, userID primitive.ObjectID, connectionIDs []string) error
+	// Online reports which of the users have at least one live connection.
+	Online(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error)
+}
+
+// MemoryPresenceStore is an in-process PresenceStore, for a single replica.
+// Its connections go away with the process, so they never need to expire.
+type MemoryPresenceStore struct {
+	mu          sync.Mutex
+	connections map[primitive.ObjectID]map[string]bool
+}
+
+// NewMemoryPresenceStore creates an empty in-process presence store.
+func NewMemoryPresenceStore() *MemoryPresenceStore {
+	return &MemoryPresenceStore{
+		connections: make(map[primitive.ObjectID]map[string]bool),
+	}
+}
+
+func (s *MemoryPresenceStore) Add(ctx context.Context, userID primitive.ObjectID, connectionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	connections, ok := s.connections[userID]
+	if !ok {
+		connections = make(map[string]bool)
+		s.connections[userID] = connections
+	}
+	connections[connectionID] = true
+	return len(connections) == 1, nil
+}
+
+func (s *MemoryPresenceStore) Remove(ctx context.Context, userID primitive.ObjectID, connectionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.connections[userID], connectionID)
+	if len(s.connections[userID]) > 0 {
+		return false, nil
+	}
+	delete(s.connections, userID)
+	return true, nil
+}
+
+func (s *MemoryPresenceStore) Refresh(ctx context.Context, userID primitive.ObjectID, connectionIDs []string) error {
+	return nil
+}
+
+func (s *MemoryPresenceStore) Online(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	online := make(map[primitive.ObjectID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if len(s.connections[userID]) > 0 {
+			online[userID] = true
+		}
+	}
+	return online, nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RedisPresenceStore is a PresenceStore shared by every replica. The connections of
+// a user are a sorted set scored by the time they expire, so a connection left
+// behind by a dead replica stops counting once it is no longer refreshed.
+type RedisPresenceStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisPresenceStore creates a presence store on the given client. Keys are
+// namespaced with prefix, and connections expire ttl after their last refresh.
+func NewRedisPresenceStore(client redis.UniversalClient, prefix string, ttl time.Duration) *RedisPresenceStore {
+	return &RedisPresenceStore{client: client, prefix: prefix, ttl: ttl}
+}
+
+// Add records the connection, dropping the expired ones first, and reports whether
+// it is the only one left. The whole check runs in a MULTI block, so of two
+// connections added at once on different replicas, only one is the first.
+func (s *RedisPresenceStore) Add(ctx context.Context, userID primitive.ObjectID, connectionID string) (bool, error) {
+	key := s.key(userID)
+	now := time.Now()
+
+	var count *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", presenceScore(now))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(s.ttl).UnixMilli()), Member: connectionID})
+		count = pipe.ZCard(ctx, key)
+		pipe.PExpire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return count.Val() == 1, nil
+}
+
+// Remove drops the connection, along with the expired ones, and reports whether
+// none is left.
+func (s *RedisPresenceStore) Remove(ctx context.Context, userID primitive.ObjectID, connectionID string) (bool, error) {
+	key := s.key(userID)
+
+	var count *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, key, connectionID)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", presenceScore(time.Now()))
+		count = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return count.Val() == 0, nil
+}
+
+// Refresh pushes back the expiry of the connections. Connections that were
+// removed meanwhile are not added back.
+func (s *RedisPresenceStore) Refresh(ctx context.Context, userID primitive.ObjectID, connectionIDs []string) error {
+	if len(connectionIDs) == 0 {
+		return nil
+	}
+
+	key := s.key(userID)
+	expiresAt := float64(time.Now().Add(s.ttl).UnixMilli())
+	members := make([]redis.Z, 0, len(connectionIDs))
+	for _, connectionID := range connectionIDs {
+		members = append(members, redis.Z{Score: expiresAt, Member: connectionID})
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddXX(ctx, key, members...)
+		pipe.PExpire(ctx, key, s.ttl)
+		return nil
+	})
+	return err
+}
+
+// Online counts the live connections of each user in a single round trip.
+func (s *RedisPresenceStore) Online(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	online := make(map[primitive.ObjectID]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	now := presenceScore(time.Now())
+	counts := make([]*redis.IntCmd, len(userIDs))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			counts[i] = pipe.ZCount(ctx, s.key(userID), "("+now, "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, userID := range userIDs {
+		if counts[i].Val() > 0 {
+			online[userID] = true
+		}
+	}
+	return online, nil
+}
+
+// key is the sorted set holding the connections of a user.
+func (s *RedisPresenceStore) key(userID primitive.ObjectID) string {
+	return s.prefix + "presence:" + userID.Hex()
+}
+
+// presenceScore formats a time as a sorted set score, in milliseconds.
+func presenceScore(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
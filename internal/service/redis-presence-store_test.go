@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestPresenceStores creates two stores sharing a Redis server, like two replicas.
+func newTestPresenceStores(t *testing.T, ttl time.Duration) (*RedisPresenceStore, *RedisPresenceStore) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	newStore := func() *RedisPresenceStore {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedisPresenceStore(client, "test:", ttl)
+	}
+	return newStore(), newStore()
+}
+
+func TestRedisPresenceStoreAcrossReplicas(t *testing.T) {
+	replicaA, replicaB := newTestPresenceStores(t, time.Minute)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	first, err := replicaA.Add(ctx, userID, "a")
+	if err != nil || !first {
+		t.Fatalf("first connection: first = %v, err = %v", first, err)
+	}
+	first, err = replicaB.Add(ctx, userID, "b")
+	if err != nil || first {
+		t.Fatalf("second connection on another replica: first = %v, err = %v", first, err)
+	}
+
+	online, err := replicaB.Online(ctx, []primitive.ObjectID{userID, primitive.NewObjectID()})
+	if err != nil {
+		t.Fatalf("online: %v", err)
+	}
+	if len(online) != 1 || !online[userID] {
+		t.Fatalf("online = %v, want only %s", online, userID.Hex())
+	}
+
+	last, err := replicaA.Remove(ctx, userID, "a")
+	if err != nil || last {
+		t.Fatalf("remove with a connection left on another replica: last = %v, err = %v", last, err)
+	}
+	last, err = replicaB.Remove(ctx, userID, "b")
+	if err != nil || !last {
+		t.Fatalf("remove the last connection: last = %v, err = %v", last, err)
+	}
+
+	online, err = replicaA.Online(ctx, []primitive.ObjectID{userID})
+	if err != nil {
+		t.Fatalf("online: %v", err)
+	}
+	if online[userID] {
+		t.Fatal("the user is still online after their last connection was removed")
+	}
+}
+
+func TestRedisPresenceStoreExpiresStaleConnections(t *testing.T) {
+	ttl := 100 * time.Millisecond
+	live, dead := newTestPresenceStores(t, ttl)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// A replica goes away without removing its connection
+	if _, err := dead.Add(ctx, userID, "dead"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	time.Sleep(2 * ttl)
+
+	online, err := live.Online(ctx, []primitive.ObjectID{userID})
+	if err != nil {
+		t.Fatalf("online: %v", err)
+	}
+	if online[userID] {
+		t.Fatal("an expired connection still counts")
+	}
+
+	first, err := live.Add(ctx, userID, "live")
+	if err != nil || !first {
+		t.Fatalf("add after the other connection expired: first = %v, err = %v", first, err)
+	}
+
+	// A refreshed connection outlives the TTL
+	for i := 0; i < 4; i++ {
+		time.Sleep(ttl / 2)
+		if err := live.Refresh(ctx, userID, []string{"live"}); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	}
+	online, err = live.Online(ctx, []primitive.ObjectID{userID})
+	if err != nil {
+		t.Fatalf("online: %v", err)
+	}
+	if !online[userID] {
+		t.Fatal("a refreshed connection expired")
+	}
+
+	// Refreshing a removed connection does not bring it back
+	if _, err := live.Remove(ctx, userID, "live"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := live.Refresh(ctx, userID, []string{"live"}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	online, err = live.Online(ctx, []primitive.ObjectID{userID})
+	if err != nil {
+		t.Fatalf("online: %v", err)
+	}
+	if online[userID] {
+		t.Fatal("refreshing a removed connection brought it back")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"time"
 )
 
 // CustomError represents a custom error with additional fields for error code, HTTP status code, service name, and success status.
@@ -13,6 +14,8 @@ type CustomError struct {
 	HTTPStatusCode int    `json:"httpStatusCode,omitempty"`
 	Service        string `json:"service,omitempty"`
 	Success        bool   `json:"success,omitempty"`
+	// RetryAt tells the client when the request may succeed if retried.
+	RetryAt *time.Time `json:"retryAt,omitempty"`
 }
 
 // Error returns the error message of the CustomError.
@@ -58,11 +61,47 @@ func NewUnauthenticatedError(message string) *CustomError {
 	return newError(message, 401, http.StatusUnauthorized)
 }
 
+// NewForbiddenError creates a new CustomError for forbidden access (HTTP 403).
+func NewForbiddenError(message string) *CustomError {
+	return newError(message, 403, http.StatusForbidden)
+}
+
+// NewTooManyRequestsError creates a new CustomError for rate limited requests (HTTP 429).
+func NewTooManyRequestsError(message string) *CustomError {
+	return newError(message, 429, http.StatusTooManyRequests)
+}
+
 // NewNotFoundError creates a new CustomError for not found errors (HTTP 404).
 func NewNotFoundError(message string) *CustomError {
 	return newError(message, 404, http.StatusNotFound)
 }
 
+// RetryAfter records when the request may succeed if retried and returns the error.
+func (e *CustomError) RetryAfter(at time.Time) *CustomError {
+	e.RetryAt = &at
+	return e
+}
+
+// Code returns a stable, machine-readable code for the error derived from its HTTP status code.
+func (e *CustomError) Code() string {
+	switch e.HTTPStatusCode {
+	case http.StatusBadRequest:
+		return "BAD_REQUEST"
+	case http.StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusTooManyRequests:
+		return "TOO_MANY_REQUESTS"
+	default:
+		return "INTERNAL_SERVER_ERROR"
+	}
+}
+
 // ToJSON converts the CustomError to a JSON byte slice.
 func (e *CustomError) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
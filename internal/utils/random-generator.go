@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"math/big"
 	"time"
@@ -24,3 +25,12 @@ func GenerateRandomNumber() (string, error) {
 func GetOtpExpiryTime() time.Time {
 	return time.Now().Add(10 * time.Minute)
 }
+
+// GenerateToken generates a random URL-safe token of 32 characters, for use in links.
+func GenerateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/middleware"
+	"simple-chat-app/internal/utils"
+)
+
+// tokenSubprotocol is the Sec-WebSocket-Protocol marker a browser client sends
+// before its JWT, e.g. `new WebSocket(url, ["access_token", token])`.
+// The server echoes the marker back so the browser accepts the upgrade.
+const tokenSubprotocol = "access_token"
+
+var errMissingToken = errors.New("access token is missing")
+
+// extractToken looks for a JWT on the handshake request. Browsers cannot set
+// headers on a WebSocket upgrade, so besides the usual Authorization header we
+// also accept the access_token query parameter and the Sec-WebSocket-Protocol header.
+func extractToken(r *http.Request) (string, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return "", errors.New("Authorization header is missing or invalid")
+		}
+		return strings.TrimPrefix(authHeader, "Bearer "), nil
+	}
+
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, nil
+	}
+
+	protocols := websocketSubprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == tokenSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], nil
+		}
+	}
+
+	return "", errMissingToken
+}
+
+// websocketSubprotocols splits the Sec-WebSocket-Protocol header into its values.
+func websocketSubprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// authenticate verifies the handshake token with the same rules as
+// middleware.VerifyToken and returns the authenticated user ID.
+func (ws *MyWebSocketServer) authenticate(r *http.Request) (primitive.ObjectID, error) {
+	token, err := extractToken(r)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	userID, err := middleware.ParseAccessToken(token, ws.accessTokenSecret)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid token format: user_id is not a valid id")
+	}
+	return id, nil
+}
+
+// rejectHandshake answers a failed handshake with the same JSON error shape the REST API uses.
+func rejectHandshake(w http.ResponseWriter, err error) {
+	body, _ := utils.NewUnauthorizedError(err.Error()).ToJSON()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(body)
+}
@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Broker carries gateway deliveries between API replicas. Every replica publishes
+// outgoing events to the topic of each recipient and subscribes to the topics of the
+// users connected to it, so an event reaches a socket whichever replica it is on.
+type Broker interface {
+	// Publish sends a payload to every current subscriber of the topic, on any replica.
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe calls handler with every payload published to the topic until the
+	// returned unsubscribe function is called. Handlers may be called concurrently.
+	Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (unsubscribe func() error, err error)
+	// Close releases the broker's resources.
+	Close() error
+}
+
+// UserTopic is the topic carrying the events addressed to one user.
+func UserTopic(userID primitive.ObjectID) string {
+	return "user:" + userID.Hex()
+}
@@ -0,0 +1,218 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/utils"
+)
+
+// outbound is a frame waiting in a client's send queue. onWritten, if set, is
+// called by the write pump once the frame has been written to the connection.
+// Chat messages also carry their conversation and sequence number.
+type outbound struct {
+	data           []byte
+	onWritten      func()
+	conversationID primitive.ObjectID
+	seq            int64
+}
+
+// Client is a single authenticated WebSocket connection.
+// A user may hold several clients at once, one per device.
+//
+// gorilla/websocket allows one concurrent reader and one concurrent writer, so
+// every client runs two goroutines: readPump, which owns all reads, and
+// writePump, which owns all writes. Everybody else hands frames to the client
+// through its bounded send queue and never touches the connection directly.
+type Client struct {
+	id     string
+	conn   *websocket.Conn
+	userID primitive.ObjectID
+	config Config
+
+	send       chan outbound
+	done       chan struct{}
+	writerDone chan struct{}
+	closeOnce  sync.Once
+	closeCode  int
+	closeText  string
+
+	typingLimiter *rateLimiter
+	typingMu      sync.Mutex
+	typing        map[primitive.ObjectID]*typingIndicator
+
+	resumeMu sync.Mutex
+	resuming bool
+	held     []outbound
+}
+
+// newClient binds an upgraded connection to the user that authenticated the handshake.
+func newClient(conn *websocket.Conn, userID primitive.ObjectID, config Config) *Client {
+	return &Client{
+		id:     primitive.NewObjectID().Hex(),
+		conn:   conn,
+		userID: userID,
+		config: config,
+		send:   make(chan outbound, config.SendQueueSize),
+		done:   make(chan struct{}),
+
+		writerDone: make(chan struct{}),
+
+		typingLimiter: newRateLimiter(config.TypingBurst, config.TypingInterval),
+		typing:        make(map[primitive.ObjectID]*typingIndicator),
+	}
+}
+
+// resolveSender returns the user the client is authenticated as. A senderId in the
+// payload is optional, but when present it must match the authenticated user.
+func (c *Client) resolveSender(senderID primitive.ObjectID) (primitive.ObjectID, error) {
+	if senderID.IsZero() {
+		return c.userID, nil
+	}
+	if senderID != c.userID {
+		return primitive.NilObjectID, utils.NewUnauthorizedError("senderId does not match the authenticated user")
+	}
+	return senderID, nil
+}
+
+// readPump reads frames from the connection and passes them to handle until the
+// connection fails or stays silent for longer than PongWait.
+func (c *Client) readPump(handle func(messageType int, message []byte)) {
+	c.conn.SetReadLimit(c.config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+	})
+
+	for {
+		messageType, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logError("Error reading message", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+		handle(messageType, message)
+	}
+}
+
+// writePump writes queued frames and periodic pings to the connection. It is the
+// only goroutine that writes to the connection; on exit it closes the connection
+// and writerDone.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.config.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.writerDone)
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message.data); err != nil {
+				logError("Error writing message", err)
+				c.close(0, "")
+				return
+			}
+			if message.onWritten != nil {
+				message.onWritten()
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logError("Error writing ping", err)
+				c.close(0, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode != 0 {
+				message := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.config.WriteWait))
+			}
+			return
+		}
+	}
+}
+
+// enqueue queues a frame for the write pump without blocking. When the queue is
+// full the configured SlowConsumerPolicy decides whether the frame is dropped or
+// the client is disconnected. It reports whether the frame was queued.
+func (c *Client) enqueue(message outbound) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+	}
+
+	switch c.config.SlowConsumerPolicy {
+	case SlowConsumerDrop:
+		log.Printf("Dropping frame for slow client of user %s", c.userID.Hex())
+	default:
+		log.Printf("Disconnecting slow client of user %s", c.userID.Hex())
+		c.close(websocket.ClosePolicyViolation, "send queue overflow")
+	}
+	return false
+}
+
+// errClientClosed is returned when waiting to queue a frame for a client that went away.
+var errClientClosed = errors.New("client closed")
+
+// enqueueWait queues a frame for the write pump, waiting for room in the queue
+// instead of applying the SlowConsumerPolicy. It gives up when the client closes
+// or ctx is done. Replays use it: the client asked for every one of those frames.
+func (c *Client) enqueueWait(ctx context.Context, message outbound) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return nil
+	case <-c.done:
+		return errClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendFrame marshals a frame and queues it for the client.
+func (c *Client) sendFrame(frame *Envelope) error {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("error marshalling %s frame: %v", frame.Type, err)
+	}
+	if !c.enqueue(outbound{data: message}) {
+		return fmt.Errorf("%s frame not queued for user %s", frame.Type, c.userID.Hex())
+	}
+	return nil
+}
+
+// close asks the write pump to send a close frame with the given code and shut
+// the connection down. A zero code skips the close frame, for connections that
+// are already broken. It is safe to call more than once and from any goroutine.
+func (c *Client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
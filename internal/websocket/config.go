@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens to a client whose send queue is full.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerClose disconnects the client; it can reconnect and catch up.
+	SlowConsumerClose SlowConsumerPolicy = "close"
+	// SlowConsumerDrop keeps the client connected and discards the frame that did not fit.
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+)
+
+// Config holds the tunables of the gateway connections.
+type Config struct {
+	// WriteWait is the time allowed to write a single frame to a client.
+	WriteWait time.Duration
+	// PongWait is the time allowed to read the next pong (or any frame) from a client.
+	PongWait time.Duration
+	// PingPeriod is how often pings are sent; it must be shorter than PongWait.
+	PingPeriod time.Duration
+	// MaxMessageSize is the largest frame accepted from a client, in bytes.
+	MaxMessageSize int64
+	// SendQueueSize is the number of outbound frames buffered per client.
+	SendQueueSize int
+	// SlowConsumerPolicy applies when a client's send queue overflows.
+	SlowConsumerPolicy SlowConsumerPolicy
+	// TypingTimeout is how long a typing indicator lasts without being refreshed.
+	TypingTimeout time.Duration
+	// TypingBurst is how many typing frames a client may send back to back.
+	TypingBurst int
+	// TypingInterval is how often a client earns one more typing frame.
+	TypingInterval time.Duration
+	// ResumeReplayLimit is the most messages replayed per conversation by one resume.
+	ResumeReplayLimit int64
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+func DefaultConfig() Config {
+	pongWait := 60 * time.Second
+	return Config{
+		WriteWait:          10 * time.Second,
+		PongWait:           pongWait,
+		PingPeriod:         (pongWait * 9) / 10,
+		MaxMessageSize:     64 * 1024,
+		SendQueueSize:      256,
+		SlowConsumerPolicy: SlowConsumerClose,
+		TypingTimeout:      6 * time.Second,
+		TypingBurst:        5,
+		TypingInterval:     time.Second,
+		ResumeReplayLimit:  500,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by the WS_* environment variables:
+// WS_WRITE_WAIT, WS_PONG_WAIT (durations such as "10s"), WS_MAX_MESSAGE_SIZE,
+// WS_SEND_QUEUE_SIZE, WS_SLOW_CONSUMER_POLICY ("close" or "drop") and WS_TYPING_TIMEOUT.
+func ConfigFromEnv() Config {
+	config := DefaultConfig()
+
+	if d, ok := envDuration("WS_WRITE_WAIT"); ok {
+		config.WriteWait = d
+	}
+	if d, ok := envDuration("WS_PONG_WAIT"); ok {
+		config.PongWait = d
+		config.PingPeriod = (d * 9) / 10
+	}
+	if n, ok := envInt("WS_MAX_MESSAGE_SIZE"); ok {
+		config.MaxMessageSize = int64(n)
+	}
+	if n, ok := envInt("WS_SEND_QUEUE_SIZE"); ok {
+		config.SendQueueSize = n
+	}
+	if d, ok := envDuration("WS_TYPING_TIMEOUT"); ok {
+		config.TypingTimeout = d
+	}
+	switch policy := SlowConsumerPolicy(os.Getenv("WS_SLOW_CONSUMER_POLICY")); policy {
+	case "":
+	case SlowConsumerClose, SlowConsumerDrop:
+		config.SlowConsumerPolicy = policy
+	default:
+		log.Printf("Ignoring unknown WS_SLOW_CONSUMER_POLICY %q", policy)
+	}
+
+	return config
+}
+
+// envDuration reads a positive duration from the environment.
+func envDuration(key string) (time.Duration, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Ignoring invalid %s %q", key, value)
+		return 0, false
+	}
+	return d, true
+}
+
+// envInt reads a positive integer from the environment.
+func envInt(key string) (int, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Ignoring invalid %s %q", key, value)
+		return 0, false
+	}
+	return n, true
+}
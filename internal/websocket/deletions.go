@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// NotifyMessageDeleted tells the participants of a conversation that a message was
+// deleted for everyone, or only the user's devices when they deleted it for
+// themselves. It is used by the REST API so that deletions made there reach
+// connected clients.
+func (ws *MyWebSocketServer) NotifyMessageDeleted(conversation *model.Conversation, message *model.Message, userID primitive.ObjectID, forEveryone bool) error {
+	return ws.deliverDeletion(conversation, message, userID, forEveryone, nil)
+}
+
+// handleDeleteMessage processes a request to delete a message, for the client's user
+// only or for everyone
+func (ws *MyWebSocketServer) handleDeleteMessage(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload DeleteMessagePayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var message *model.Message
+	if payload.ForEveryone {
+		message, err = ws.messageService.DeleteForEveryone(ctx, conversation, payload.MessageId, client.userID)
+	} else {
+		message, err = ws.messageService.DeleteForMe(ctx, conversation, payload.MessageId, client.userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering message deletion", ws.deliverDeletion(conversation, message, client.userID, payload.ForEveryone, client))
+	return newMessageDeletedEvent(message, payload.ForEveryone), nil
+}
+
+// deliverDeletion publishes a message_deleted event to whoever the deletion applies to.
+func (ws *MyWebSocketServer) deliverDeletion(conversation *model.Conversation, message *model.Message, userID primitive.ObjectID, forEveryone bool, exclude *Client) error {
+	audience := []primitive.ObjectID{userID}
+	if forEveryone {
+		audience = conversation.ParticipantIDs()
+	}
+	return ws.deliverToUsers(audience, TypeDeleted, newMessageDeletedEvent(message, forEveryone), exclude)
+}
+
+func newMessageDeletedEvent(message *model.Message, forEveryone bool) *MessageDeletedEvent {
+	event := &MessageDeletedEvent{
+		ConversationId: message.ConversationId,
+		MessageId:      message.ID,
+		Seq:            message.Seq,
+		ForEveryone:    forEveryone,
+	}
+	if forEveryone {
+		event.DeletedBy = message.DeletedBy
+	}
+	return event
+}
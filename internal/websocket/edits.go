@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// NotifyMessageEdited tells the participants of a conversation that a message was
+// edited, and sends a mention event to the users the edit newly mentions. It is used
+// by the REST API so that edits made there reach connected clients.
+func (ws *MyWebSocketServer) NotifyMessageEdited(conversation *model.Conversation, message *model.Message, mentioned []primitive.ObjectID) error {
+	return ws.deliverEdit(conversation, message, mentioned, nil)
+}
+
+// deliverEdit sends the new version of a message to the participants who still see
+// it, and a mention event to the users the edit newly mentions, which alerts the ones
+// whose settings let a mention through.
+func (ws *MyWebSocketServer) deliverEdit(conversation *model.Conversation, message *model.Message, mentioned []primitive.ObjectID, exclude *Client) error {
+	now := time.Now()
+	var alerted, silenced []primitive.ObjectID
+	for _, userID := range mentioned {
+		participant := conversation.Participant(userID)
+		if participant == nil {
+			continue
+		}
+		if participant.Settings.Notifies(now, true) {
+			alerted = append(alerted, userID)
+		} else {
+			silenced = append(silenced, userID)
+		}
+	}
+
+	return errors.Join(
+		ws.deliverToUsers(editRecipients(conversation, message), TypeEdited, message, exclude),
+		ws.publishMention(alerted, message, false),
+		ws.publishMention(silenced, message, true),
+	)
+}
+
+// editRecipients are the participants who still see a message, leaving out those
+// who deleted it for themselves.
+func editRecipients(conversation *model.Conversation, message *model.Message) []primitive.ObjectID {
+	var recipients []primitive.ObjectID
+	for _, userID := range conversation.ParticipantIDs() {
+		if !message.HiddenFrom(userID) {
+			recipients = append(recipients, userID)
+		}
+	}
+	return recipients
+}
+
+// handleEditMessage processes a request to edit one of the client's messages, and
+// sends the new version to the participants
+func (ws *MyWebSocketServer) handleEditMessage(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload EditMessagePayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, mentioned, err := ws.messageService.Edit(ctx, conversation, payload.MessageId, client.userID, payload.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering message edit", ws.deliverEdit(conversation, message, mentioned, client))
+	return message, nil
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -10,26 +11,64 @@ import (
 	"net/http"
 	"simple-chat-app/internal/model"
 	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+	"sync"
+	"time"
 )
 
 /**
 MyWebSocketServer STRUCT: A WebSocket server in Go using the gorilla/websocket package.
-The server is encapsulated in the MyWebSocketServer struct, which maintains a list of connected clients,
-channels for broadcasting messages, and services for handling conversations and messages.
+The server is encapsulated in the MyWebSocketServer struct, which maintains a registry of connected clients,
+channels for delivering messages, and services for handling conversations and messages.
 The MyWebSocketServer struct has several fields:
-clients: a map that tracks active WebSocket connections.
-broadcast: a channel for broadcasting messages to all clients.
+clients: a registry that tracks active, authenticated WebSocket clients per user.
+broker: carries every delivery, so it reaches the user's clients on whichever replica they are connected.
+subscriptions and subscribed: the broker subscriptions of the users connected to this replica, made off the hub, which hears of them on subscribed.
+deliver: a channel for deliveries received from the broker, to be queued on local clients.
+delivered: a queue of messages written to recipients, waiting for their delivery receipt.
+goingAway: asks the hub to close every client during shutdown.
+draining, connections: refuse new connections during shutdown and wait for open ones to finish.
 register and unregister: channels for managing client connections.
 conversationService and messageService: services for handling conversation and message logic.
+presenceService: tracks which users are online and is notified as clients come and go.
+accessTokenSecret: the JWT secret used to authenticate the handshake.
+config: the connection tunables (deadlines, queue sizes, slow consumer policy).
 */
 
 type MyWebSocketServer struct {
-	clients             map[*websocket.Conn]bool
-	broadcast           chan []byte
-	register            chan *websocket.Conn
-	unregister          chan *websocket.Conn
+	clients             *registry
+	broker              Broker
+	subscriptions       map[primitive.ObjectID]*subscription
+	subscribed          chan subscribeResult
+	deliver             chan *delivery
+	delivered           chan deliveredReceipt
+	receiptsDone        chan struct{}
+	goingAway           chan struct{}
+	lifecycleMu         sync.Mutex
+	draining            bool
+	connections         sync.WaitGroup
+	register            chan *Client
+	unregister          chan *Client
 	conversationService *service.ConversationService
 	messageService      *service.MessageService
+	presenceService     *service.PresenceService
+	accessTokenSecret   string
+	config              Config
+}
+
+// delivery is a frame addressed to every connected client of a user. It travels
+// through the Broker as JSON, so clients are referred to by ID: the Exclude client,
+// usually the one that triggered the delivery, is skipped. Deliveries of a chat
+// message with receipts also name the message, so that writing it to a recipient
+// other than the sender produces a delivery receipt on the replica that wrote it.
+type delivery struct {
+	UserID         primitive.ObjectID `json:"-"`
+	Payload        json.RawMessage    `json:"payload"`
+	Exclude        string             `json:"exclude,omitempty"`
+	ConversationID primitive.ObjectID `json:"conversationId"`
+	MessageID      primitive.ObjectID `json:"messageId"`
+	SenderID       primitive.ObjectID `json:"senderId"`
+	Seq            int64              `json:"seq,omitempty"`
 }
 
 //The upgrader variable is a websocket.Upgrader that allows all origins to connect. This is used to upgrade HTTP connections to WebSocket connections.
@@ -38,221 +77,615 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: []string{tokenSubprotocol},
 }
 
 /**
 * The NewWebSocketServer function initializes a new instance of MyWebSocketServer, setting up the channels and services.
  */
 
-func NewWebSocketServer(conversationService *service.ConversationService, messageService *service.MessageService) *MyWebSocketServer {
+func NewWebSocketServer(conversationService *service.ConversationService, messageService *service.MessageService, presenceService *service.PresenceService, broker Broker, accessTokenSecret string, config Config) *MyWebSocketServer {
 	return &MyWebSocketServer{
-		clients:             make(map[*websocket.Conn]bool),
-		broadcast:           make(chan []byte),
-		register:            make(chan *websocket.Conn),
-		unregister:          make(chan *websocket.Conn),
+		clients:             newRegistry(),
+		broker:              broker,
+		subscriptions:       make(map[primitive.ObjectID]*subscription),
+		subscribed:          make(chan subscribeResult),
+		deliver:             make(chan *delivery),
+		delivered:           make(chan deliveredReceipt, 1024),
+		receiptsDone:        make(chan struct{}),
+		goingAway:           make(chan struct{}),
+		register:            make(chan *Client),
+		unregister:          make(chan *Client),
 		conversationService: conversationService,
 		messageService:      messageService,
+		presenceService:     presenceService,
+		accessTokenSecret:   accessTokenSecret,
+		config:              config,
 	}
 }
 
-// The Start method starts the WebSocket server, setting up the HTTP handler and starting the message handling goroutine.
+// The Start method starts the message handling and receipt goroutines. Connections are served by the
+// main HTTP server through HandleConnections.
 func (ws *MyWebSocketServer) Start() {
-	http.HandleFunc("/ws/chat", ws.HandleConnections)
 	go ws.handleMessages()
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	go ws.handleReceipts()
+}
+
+// Shutdown stops accepting connections, sends every client a close frame with the
+// "going away" code, waits for the open connections to finish their in-flight work
+// and drains the pending delivery receipts. It returns ctx.Err() if ctx expires first.
+// Shutdown must be called at most once.
+func (ws *MyWebSocketServer) Shutdown(ctx context.Context) error {
+	ws.lifecycleMu.Lock()
+	ws.draining = true
+	ws.lifecycleMu.Unlock()
+
+	close(ws.goingAway)
+
+	connectionsDone := make(chan struct{})
+	go func() {
+		ws.connections.Wait()
+		// No write pump is left to queue receipts
+		close(ws.delivered)
+		<-ws.receiptsDone
+		close(connectionsDone)
+	}()
+
+	select {
+	case <-connectionsDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return ws.broker.Close()
+}
+
+// isDraining reports whether Shutdown has started.
+func (ws *MyWebSocketServer) isDraining() bool {
+	ws.lifecycleMu.Lock()
+	defer ws.lifecycleMu.Unlock()
+
+	return ws.draining
 }
 
 /**
-* The handleMessages method listens for events on the register, unregister, and broadcast channels.
-* When a new client connects, it is added to the clients map.
-* When a client disconnects, it is removed from the map and the connection is closed.
-* When a message is received on the broadcast channel, it is sent to all connected clients.
+* The handleMessages method listens for events on the register, unregister, and deliver channels.
+* When a new client connects, it is added to the registry under its user; the user's
+* first client subscribes this replica to the user's broker topic.
+* When a client disconnects, it is removed from the registry and the connection is closed;
+* the user's last client unsubscribes from the topic.
+* When a delivery arrives from the broker, it is queued on every local client of its user.
+* Every third of service.PresenceTTL, the connections of the local clients are refreshed in the presence store.
+* When the server shuts down, every client is sent a "going away" close frame.
+* The hub never writes to a connection itself, so a stalled client cannot block it.
  */
 
 func (ws *MyWebSocketServer) handleMessages() {
+	presenceTicker := time.NewTicker(service.PresenceTTL / 3)
+	defer presenceTicker.Stop()
+
+	goingAway := ws.goingAway
 	for {
 		select {
-		case conn := <-ws.register:
-			ws.clients[conn] = true
-		case conn := <-ws.unregister:
-			if _, ok := ws.clients[conn]; ok {
-				delete(ws.clients, conn)
-				conn.Close()
+		case client := <-ws.register:
+			if ws.clients.add(client) {
+				ws.subscribe(client.userID)
+			}
+		case client := <-ws.unregister:
+			removed, last := ws.clients.remove(client)
+			if removed {
+				client.close(0, "")
 			}
-		case message := <-ws.broadcast:
-			for conn := range ws.clients {
-				err := conn.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					log.Printf("Error writing message: %v", err)
-					conn.Close()
-					delete(ws.clients, conn)
+			if last {
+				ws.unsubscribe(client.userID)
+			}
+		case result := <-ws.subscribed:
+			ws.completeSubscription(result)
+		case d := <-ws.deliver:
+			for client := range ws.clients.clientsOf(d.UserID) {
+				if client.id == d.Exclude {
+					continue
 				}
+				client.deliver(ws.outboundFor(d, client))
 			}
+		case <-presenceTicker.C:
+			connections := make(map[primitive.ObjectID][]string)
+			ws.clients.each(func(client *Client) {
+				connections[client.userID] = append(connections[client.userID], client.id)
+			})
+			go ws.refreshPresence(connections)
+		case <-goingAway:
+			ws.clients.each(func(client *Client) {
+				client.close(websocket.CloseGoingAway, "server shutting down")
+			})
+			goingAway = nil
 		}
 	}
 }
 
+// HandleConnections authenticates the handshake, upgrades it to a WebSocket and
+// serves the connection until the client goes away. Unauthenticated callers are
+// rejected with 401 before the upgrade, and every caller with 503 once the server
+// is shutting down.
 func (ws *MyWebSocketServer) HandleConnections(w http.ResponseWriter, r *http.Request) {
+	ws.lifecycleMu.Lock()
+	if ws.draining {
+		ws.lifecycleMu.Unlock()
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	ws.connections.Add(1)
+	ws.lifecycleMu.Unlock()
+	defer ws.connections.Done()
+
+	userID, err := ws.authenticate(r)
+	if err != nil {
+		logError("Rejected WebSocket handshake", err)
+		rejectHandshake(w, err)
+		return
+	}
+
 	// Upgrade the HTTP connection to a WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logError("Error upgrading to WebSocket", err)
 		return
 	}
-	defer conn.Close()
 
-	log.Printf("Client connected: %s", conn.RemoteAddr())
+	client := newClient(conn, userID, ws.config)
+	log.Printf("Client connected: %s (user %s)", conn.RemoteAddr(), userID.Hex())
 
 	// Register the connection
-	ws.register <- conn
-
-	for {
-		messageType, message, err := conn.ReadMessage()
-		if err != nil {
-			logError("Error reading message", err)
-			ws.unregister <- conn
-			break
-		}
-
-		// Broadcast the message if needed
-		ws.broadcast <- message
-
-		// Process the message
-		if err := ws.processMessage(r.Context(), conn, messageType, message); err != nil {
-			logError("Error processing message", err)
-			continue
-		}
+	ws.register <- client
+	go client.writePump()
+	if ws.isDraining() {
+		// Registered after the hub said goodbye to everyone else
+		client.close(websocket.CloseGoingAway, "server shutting down")
 	}
+	ws.userConnected(client)
+
+	// Process messages until the client goes away; failures are reported back to
+	// the client as error frames
+	client.readPump(func(messageType int, message []byte) {
+		ws.processMessage(r.Context(), client, messageType, message)
+	})
+
+	ws.unregister <- client
+	client.close(0, "")
+	<-client.writerDone
+	ws.clearTyping(client)
+	ws.userDisconnected(client)
 }
 
-// processMessage handles incoming messages based on the "action" field
-func (ws *MyWebSocketServer) processMessage(ctx context.Context, conn *websocket.Conn, messageType int, message []byte) error {
-	var request map[string]interface{}
-	if err := json.Unmarshal(message, &request); err != nil {
-		return fmt.Errorf("invalid message format: %v", err)
+// processMessage decodes an incoming frame, dispatches it on its type and answers
+// the client with an ack frame carrying the result or an error frame.
+func (ws *MyWebSocketServer) processMessage(ctx context.Context, client *Client, messageType int, message []byte) {
+	if messageType != websocket.TextMessage {
+		ws.sendError(client, "", utils.NewBadRequestError("only text frames are supported"))
+		return
 	}
 
-	action, ok := request["action"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid action field")
+	env, err := decodeEnvelope(message)
+	if err != nil {
+		id := ""
+		if env != nil {
+			id = env.ID
+		}
+		ws.sendError(client, id, err)
+		return
 	}
 
-	switch action {
-	case "create_conversation":
-		return ws.handleCreateConversation(request)
+	var result interface{}
+	switch env.Type {
+	case TypeCreateConversation:
+		result, err = ws.handleCreateConversation(client, env)
+
+	case TypeGetConversation:
+		result, err = ws.handleGetConversationById(ctx, client, env)
+
+	case TypeSendMessage:
+		result, err = ws.handleSendMessage(ctx, client, env)
 
-	case "get_conversationById":
-		return ws.handleGetConversationById(ctx, conn, request)
+	case TypeTypingStart:
+		result, err = ws.handleTypingStart(ctx, client, env)
 
-	case "send_message":
-		return ws.handleSendMessage(conn, request)
+	case TypeTypingStop:
+		result, err = ws.handleTypingStop(client, env)
+
+	case TypeMarkRead:
+		result, err = ws.handleMarkRead(ctx, client, env)
+
+	case TypeResume:
+		result, err = ws.handleResume(ctx, client, env)
+
+	case TypeCreateGroup:
+		result, err = ws.handleCreateGroup(ctx, client, env)
+
+	case TypeAddParticipants:
+		result, err = ws.handleAddParticipants(ctx, client, env)
+
+	case TypeRemoveParticipant:
+		result, err = ws.handleRemoveParticipant(ctx, client, env)
+
+	case TypeLeaveConversation:
+		result, err = ws.handleLeaveConversation(ctx, client, env)
+
+	case TypeSetRole:
+		result, err = ws.handleSetRole(ctx, client, env)
+
+	case TypeUpdateSettings:
+		result, err = ws.handleUpdateSettings(ctx, client, env)
+
+	case TypeSetModeration:
+		result, err = ws.handleSetModeration(ctx, client, env)
+
+	case TypeMuteMember:
+		result, err = ws.handleMuteMember(ctx, client, env)
+
+	case TypeGetMessages:
+		result, err = ws.handleGetMessages(ctx, client, env)
+
+	case TypeEditMessage:
+		result, err = ws.handleEditMessage(ctx, client, env)
+
+	case TypeDeleteMessage:
+		result, err = ws.handleDeleteMessage(ctx, client, env)
+
+	case TypeGetThread:
+		result, err = ws.handleGetThread(ctx, client, env)
+
+	case TypeFollowThread:
+		result, err = ws.handleFollowThread(ctx, client, env)
+
+	case TypeAddReaction:
+		result, err = ws.handleReaction(ctx, client, env, true)
+
+	case TypeRemoveReaction:
+		result, err = ws.handleReaction(ctx, client, env, false)
 
 	default:
-		log.Printf("Unknown action: %s", action)
-		return nil
+		err = utils.NewBadRequestError(fmt.Sprintf("unknown frame type %q", env.Type))
 	}
-}
 
-// handleCreateConversation processes a request to create a new conversation
-func (ws *MyWebSocketServer) handleCreateConversation(request map[string]interface{}) error {
-	senderID, err := parseObjectID(request["senderId"])
 	if err != nil {
-		return fmt.Errorf("invalid senderId: %v", err)
+		ws.sendError(client, env.ID, err)
+		return
 	}
+	ws.sendAck(client, env.ID, result)
+}
 
-	receiverID, err := parseObjectID(request["receiverId"])
-	if err != nil {
-		return fmt.Errorf("invalid receiverId: %v", err)
+// handleCreateConversation processes a request to create a new conversation
+func (ws *MyWebSocketServer) handleCreateConversation(client *Client, env *Envelope) (interface{}, error) {
+	var payload CreateConversationPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
 	}
 
-	conversation := model.Conversation{
-		SenderId:   senderID,
-		ReceiverId: receiverID,
+	senderID, err := client.resolveSender(payload.SenderId)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := ws.conversationService.Create(conversation); err != nil {
-		return fmt.Errorf("error creating conversation: %v", err)
+	createdConversation, err := ws.conversationService.Create(senderID, payload.ReceiverId)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Println("Conversation created successfully")
-	return nil
+	return createdConversation, nil
 }
 
 // handleGetConversationById processes a request to retrieve a conversation by ID
-func (ws *MyWebSocketServer) handleGetConversationById(ctx context.Context, conn *websocket.Conn, request map[string]interface{}) error {
-	conversationID, err := parseObjectID(request["_id"])
-	if err != nil {
-		return fmt.Errorf("invalid conversationID: %v", err)
+func (ws *MyWebSocketServer) handleGetConversationById(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload GetConversationPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
 	}
 
-	conversation, sender, receiver, err := ws.conversationService.GetConversationWithUsers(ctx, conversationID)
+	conversation, users, err := ws.conversationService.GetConversationWithUsers(ctx, payload.ID, client.userID)
 	if err != nil {
-		return fmt.Errorf("error getting conversation: %v", err)
+		return nil, err
 	}
 
-	response := map[string]interface{}{
-		"conversation": conversation,
-		"sender":       sender,
-		"receiver":     receiver,
-	}
-	return ws.sendResponse(conn, websocket.TextMessage, response)
+	return &ConversationResult{
+		Conversation: conversation,
+		Users:        users,
+	}, nil
 }
 
 // handleSendMessage processes a request to send a message
-func (ws *MyWebSocketServer) handleSendMessage(conn *websocket.Conn, request map[string]interface{}) error {
-	conversationID, err := parseObjectID(request["conversationId"])
+func (ws *MyWebSocketServer) handleSendMessage(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload SendMessagePayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	senderID, err := client.resolveSender(payload.SenderId)
 	if err != nil {
-		return fmt.Errorf("invalid conversationId: %v", err)
+		return nil, err
 	}
 
-	senderID, err := parseObjectID(request["senderId"])
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, senderID)
 	if err != nil {
-		return fmt.Errorf("invalid senderId: %v", err)
+		return nil, err
 	}
 
 	message := model.Message{
-		ConversationId: conversationID,
+		ConversationId: payload.ConversationId,
 		SenderId:       senderID,
-		Message:        request["message"].(string),
+		Message:        payload.Message,
+		ParentId:       payload.ParentId,
 	}
 
 	createdMessage, err := ws.messageService.Create(message)
 	if err != nil {
-		return fmt.Errorf("error creating message: %v", err)
+		return nil, err
+	}
+
+	// Deliver to the participants, including the sender's other devices
+	if err := ws.deliverMessage(ctx, conversation, createdMessage, client); err != nil {
+		logError("Error delivering message", err)
+	}
+	return createdMessage, nil
+}
+
+// sendAck answers the request with the given ID with its result.
+func (ws *MyWebSocketServer) sendAck(client *Client, id string, result interface{}) {
+	frame, err := newFrame(TypeAck, id, result)
+	if err != nil {
+		ws.sendError(client, id, err)
+		return
+	}
+	logFrameError(TypeAck, client.sendFrame(frame))
+}
+
+// sendError answers the request with the given ID with an error frame.
+func (ws *MyWebSocketServer) sendError(client *Client, id string, err error) {
+	frame, frameErr := newFrame(TypeError, id, errorPayloadFor(err))
+	if frameErr != nil {
+		logFrameError(TypeError, frameErr)
+		return
+	}
+	logFrameError(TypeError, client.sendFrame(frame))
+}
+
+// deliverToUsers publishes an event for delivery to every connected client of the
+// given users, on any replica, skipping the exclude client.
+func (ws *MyWebSocketServer) deliverToUsers(userIDs []primitive.ObjectID, eventType string, event interface{}, exclude *Client) error {
+	d, err := newDelivery(eventType, event, exclude, false)
+	if err != nil {
+		return err
+	}
+	return ws.publish(userIDs, d)
+}
+
+// NotifyMessage delivers a new message to the participants of its conversation. It is
+// used by the REST API so that messages posted there reach connected clients.
+func (ws *MyWebSocketServer) NotifyMessage(conversation *model.Conversation, message *model.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return ws.deliverMessage(ctx, conversation, message, nil)
+}
+
+// deliverMessage publishes a chat message for delivery to the participants of its
+// conversation. It reaches everyone, but is silent for the sender and for the
+// participants whose settings say it should not alert them. A thread reply only
+// alerts the followers of its thread and the users it mentions, who also get a
+// mention event.
+func (ws *MyWebSocketServer) deliverMessage(ctx context.Context, conversation *model.Conversation, message *model.Message, exclude *Client) error {
+	var followers map[primitive.ObjectID]bool
+	var followersErr error
+	if message.ThreadRootId != nil {
+		ids, err := ws.messageService.ThreadFollowers(ctx, *message.ThreadRootId)
+		if err != nil {
+			followersErr = fmt.Errorf("error loading the followers of thread %s: %v", message.ThreadRootId.Hex(), err)
+		}
+		followers = make(map[primitive.ObjectID]bool, len(ids))
+		for _, id := range ids {
+			followers[id] = true
+		}
+	}
+
+	now := time.Now()
+	var alerted, silenced, mentionedAlerted, mentionedSilenced []primitive.ObjectID
+	for _, participant := range conversation.Participants {
+		userID := participant.UserId
+		if userID == message.SenderId {
+			silenced = append(silenced, userID)
+			continue
+		}
+
+		mentioned := message.BroadcastsTo(userID) || message.MentionsUser(userID)
+		alert := participant.Settings.Notifies(now, mentioned) && (followers == nil || followers[userID] || mentioned)
+		switch {
+		case alert && mentioned:
+			mentionedAlerted = append(mentionedAlerted, userID)
+		case mentioned:
+			mentionedSilenced = append(mentionedSilenced, userID)
+		}
+		if alert {
+			alerted = append(alerted, userID)
+		} else {
+			silenced = append(silenced, userID)
+		}
+	}
+
+	return errors.Join(
+		followersErr,
+		ws.publishMessage(alerted, message, exclude, false),
+		ws.publishMessage(silenced, message, exclude, true),
+		ws.publishMention(mentionedAlerted, message, false),
+		ws.publishMention(mentionedSilenced, message, true),
+	)
+}
+
+// publishMention sends a mention event about a message to the users it mentions.
+func (ws *MyWebSocketServer) publishMention(userIDs []primitive.ObjectID, message *model.Message, silent bool) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	event := &MentionEvent{
+		ConversationId: message.ConversationId,
+		MessageId:      message.ID,
+		SenderId:       message.SenderId,
+		Seq:            message.Seq,
+		Broadcast:      message.Broadcast,
+	}
+	d, err := newDelivery(TypeMention, event, nil, silent)
+	if err != nil {
+		return err
 	}
+	return ws.publish(userIDs, d)
+}
 
-	response := map[string]interface{}{
-		"status":  "success",
-		"message": createdMessage,
+// publishMessage publishes a chat message for delivery to the given users.
+// Recipients other than the sender produce a delivery receipt once it is written,
+// unless the message has no receipts to update, as in channels.
+func (ws *MyWebSocketServer) publishMessage(userIDs []primitive.ObjectID, message *model.Message, exclude *Client, silent bool) error {
+	if len(userIDs) == 0 {
+		return nil
 	}
 
-	// send Response
-	return ws.sendResponse(conn, websocket.TextMessage, response)
+	d, err := newDelivery(TypeMessage, message, exclude, silent)
+	if err != nil {
+		return err
+	}
+	d.ConversationID = message.ConversationId
+	d.Seq = message.Seq
+	if len(message.Receipts) > 0 {
+		d.MessageID = message.ID
+		d.SenderID = message.SenderId
+	}
+	return ws.publish(userIDs, d)
+}
 
+// newDelivery wraps an event in a frame, marked silent if it should not alert the user.
+func newDelivery(eventType string, event interface{}, exclude *Client, silent bool) (*delivery, error) {
+	frame, err := newFrame(eventType, "", event)
+	if err != nil {
+		return nil, err
+	}
+	frame.Silent = silent
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling event: %v", err)
+	}
+
+	d := &delivery{Payload: payload}
+	if exclude != nil {
+		d.Exclude = exclude.id
+	}
+	return d, nil
 }
 
-// sendResponse sends a message to the WebSocket connection
-func (ws *MyWebSocketServer) sendResponse(conn *websocket.Conn, messageType int, response interface{}) error {
-	responseMessage, err := json.Marshal(response)
+// publish sends a delivery to the broker topic of each user.
+func (ws *MyWebSocketServer) publish(userIDs []primitive.ObjectID, d *delivery) error {
+	data, err := json.Marshal(d)
 	if err != nil {
-		return fmt.Errorf("error marshalling response: %v", err)
+		return fmt.Errorf("error marshalling delivery: %v", err)
 	}
 
-	if err := conn.WriteMessage(messageType, responseMessage); err != nil {
-		return fmt.Errorf("error sending message: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var publishErr error
+	for _, userID := range userIDs {
+		if err := ws.broker.Publish(ctx, UserTopic(userID), data); err != nil {
+			publishErr = fmt.Errorf("error publishing to user %s: %v", userID.Hex(), err)
+		}
 	}
+	return publishErr
+}
+
+// subscription is the broker subscription to the topic of a user connected to this
+// replica. Its unsubscribe function is nil until the broker has answered.
+type subscription struct {
+	unsubscribe func() error
+}
 
-	log.Println("Message sent successfully")
-	return nil
+// subscribeResult is the broker's answer to subscribing to the topic of a user.
+type subscribeResult struct {
+	userID       primitive.ObjectID
+	subscription *subscription
+	unsubscribe  func() error
+	err          error
 }
 
-// parseObjectID converts a string into a MongoDB ObjectID
-func parseObjectID(id interface{}) (primitive.ObjectID, error) {
-	idStr, ok := id.(string)
+// subscribe starts receiving the deliveries of a user who connected to this replica.
+// Called from the hub goroutine; the broker is called on another goroutine, so a
+// slow broker cannot hold up the hub, which hears back on ws.subscribed.
+func (ws *MyWebSocketServer) subscribe(userID primitive.ObjectID) {
+	sub := &subscription{}
+	ws.subscriptions[userID] = sub
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		unsubscribe, err := ws.broker.Subscribe(ctx, UserTopic(userID), func(data []byte) {
+			var d delivery
+			if err := json.Unmarshal(data, &d); err != nil {
+				logError("Error decoding delivery", err)
+				return
+			}
+			d.UserID = userID
+			ws.deliver <- &d
+		})
+		ws.subscribed <- subscribeResult{userID: userID, subscription: sub, unsubscribe: unsubscribe, err: err}
+	}()
+}
+
+// completeSubscription records the broker's answer to subscribe. When the user's
+// last client went away meanwhile, the subscription is dropped right away.
+// Called from the hub goroutine.
+func (ws *MyWebSocketServer) completeSubscription(result subscribeResult) {
+	if result.err != nil {
+		logError("Error subscribing to user topic", result.err)
+		if ws.subscriptions[result.userID] == result.subscription {
+			delete(ws.subscriptions, result.userID)
+		}
+		return
+	}
+	if ws.subscriptions[result.userID] != result.subscription {
+		go func() {
+			logError("Error unsubscribing from user topic", result.unsubscribe())
+		}()
+		return
+	}
+	result.subscription.unsubscribe = result.unsubscribe
+}
+
+// unsubscribe stops receiving the deliveries of a user whose last client on this
+// replica went away. Called from the hub goroutine; like subscribe, it leaves the
+// broker call to another goroutine. A subscription still waiting for the broker is
+// dropped by completeSubscription.
+func (ws *MyWebSocketServer) unsubscribe(userID primitive.ObjectID) {
+	sub, ok := ws.subscriptions[userID]
 	if !ok {
-		return primitive.NilObjectID, fmt.Errorf("id is not a string")
+		return
+	}
+	delete(ws.subscriptions, userID)
+	if unsubscribe := sub.unsubscribe; unsubscribe != nil {
+		go func() {
+			logError("Error unsubscribing from user topic", unsubscribe())
+		}()
+	}
+}
+
+// outboundFor builds the queued frame of a delivery for one client.
+func (ws *MyWebSocketServer) outboundFor(d *delivery, client *Client) outbound {
+	message := outbound{data: d.Payload, conversationID: d.ConversationID, seq: d.Seq}
+	if !d.MessageID.IsZero() && client.userID != d.SenderID {
+		receipt := deliveredReceipt{
+			conversationID: d.ConversationID,
+			messageID:      d.MessageID,
+			senderID:       d.SenderID,
+			userID:         client.userID,
+		}
+		message.onWritten = func() { ws.queueDelivered(receipt) }
 	}
-	return primitive.ObjectIDFromHex(idStr)
+	return message
 }
 
 // logError simplifies error logging
@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stalledBroker is a Broker whose Subscribe blocks until release is closed, like a
+// Redis server that stopped answering.
+type stalledBroker struct {
+	*MemoryBroker
+	release      chan struct{}
+	unsubscribed atomic.Int32
+}
+
+func (b *stalledBroker) Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (func() error, error) {
+	<-b.release
+	unsubscribe, err := b.MemoryBroker.Subscribe(ctx, topic, handler)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		b.unsubscribed.Add(1)
+		return unsubscribe()
+	}, nil
+}
+
+func TestHubDoesNotWaitForTheBroker(t *testing.T) {
+	broker := &stalledBroker{MemoryBroker: NewMemoryBroker(), release: make(chan struct{})}
+	ws := NewWebSocketServer(nil, nil, nil, broker, "", DefaultConfig())
+	ws.Start()
+
+	first, _ := newTestClient(t, DefaultConfig())
+	second, _ := newTestClient(t, DefaultConfig())
+
+	// Both subscriptions are stuck in the broker, yet the hub keeps serving
+	registered := make(chan struct{})
+	go func() {
+		ws.register <- first
+		ws.register <- second
+		ws.unregister <- first
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hub is blocked by the broker")
+	}
+
+	// The first user left before the broker answered: their subscription is dropped
+	close(broker.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.unsubscribed.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions dropped, want 1", broker.unsubscribed.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/utils"
+)
+
+// NotifyMembership tells the participants of a group, and the users the change is
+// about, that its membership changed. It is also used by the REST API so that
+// changes made there reach connected clients.
+func (ws *MyWebSocketServer) NotifyMembership(conversation *model.Conversation, action string, actorID primitive.ObjectID, userIDs []primitive.ObjectID) error {
+	event := &MembershipEvent{
+		ConversationId: conversation.ID,
+		Action:         action,
+		ActorId:        actorID,
+		UserIds:        userIDs,
+		Conversation:   conversation,
+	}
+
+	audience := conversation.ParticipantIDs()
+	for _, userID := range userIDs {
+		if !conversation.HasParticipant(userID) {
+			audience = append(audience, userID)
+		}
+	}
+	return ws.deliverToUsers(audience, TypeMembership, event, nil)
+}
+
+// handleCreateGroup processes a request to create a group owned by the client's user
+func (ws *MyWebSocketServer) handleCreateGroup(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload CreateGroupPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.CreateGroup(ctx, client.userID, payload.Title, payload.Avatar, payload.UserIds)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipCreated, client.userID, conversation.ParticipantIDs()))
+	return conversation, nil
+}
+
+// handleAddParticipants processes a request to add users to a group
+func (ws *MyWebSocketServer) handleAddParticipants(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload AddParticipantsPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.AddParticipants(ctx, payload.ConversationId, client.userID, payload.UserIds)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipAdded, client.userID, payload.UserIds))
+	return conversation, nil
+}
+
+// handleRemoveParticipant processes a request to remove a user from a group
+func (ws *MyWebSocketServer) handleRemoveParticipant(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload RemoveParticipantPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.RemoveParticipant(ctx, payload.ConversationId, client.userID, payload.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipRemoved, client.userID, []primitive.ObjectID{payload.UserId}))
+	return conversation, nil
+}
+
+// handleLeaveConversation processes a request of the client's user to leave a group
+func (ws *MyWebSocketServer) handleLeaveConversation(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload LeaveConversationPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Leave(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipLeft, client.userID, []primitive.ObjectID{client.userID}))
+	return conversation, nil
+}
+
+// handleSetRole processes a request to change the role of a group participant
+func (ws *MyWebSocketServer) handleSetRole(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload SetRolePayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.SetRole(ctx, payload.ConversationId, client.userID, payload.UserId, payload.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipRoleChanged, client.userID, []primitive.ObjectID{payload.UserId}))
+	return conversation, nil
+}
+
+// handleSetModeration processes a request to change the moderation rules of a group or channel
+func (ws *MyWebSocketServer) handleSetModeration(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload SetModerationPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.SetModeration(ctx, payload.ConversationId, client.userID, payload.ModerationUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipModeration, client.userID, nil))
+	return conversation, nil
+}
+
+// handleMuteMember processes a request to keep a participant from posting for a while
+func (ws *MyWebSocketServer) handleMuteMember(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload MuteMemberPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	duration, err := time.ParseDuration(payload.Duration)
+	if err != nil {
+		return nil, utils.NewBadRequestError("duration must be a duration such as 1h")
+	}
+
+	conversation, err := ws.conversationService.MuteMember(ctx, payload.ConversationId, client.userID, payload.UserId, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering membership event", ws.NotifyMembership(conversation, MembershipMuted, client.userID, []primitive.ObjectID{payload.UserId}))
+	return conversation, nil
+}
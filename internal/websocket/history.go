@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"context"
+
+	"simple-chat-app/internal/export"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+)
+
+// handleGetMessages returns a page of a conversation's history, oldest message first.
+func (ws *MyWebSocketServer) handleGetMessages(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload GetMessagesPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	query, err := payload.query()
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.messageService.History(ctx, conversation, client.userID, query)
+}
+
+// handleGetThread returns a page of the replies in a thread, oldest first, with its root.
+func (ws *MyWebSocketServer) handleGetThread(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload GetThreadPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	query, err := payload.query()
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.messageService.Thread(ctx, conversation, payload.MessageId, client.userID, query)
+}
+
+// query validates the cursor and turns it into a history query.
+func (p *PageCursor) query() (service.HistoryQuery, error) {
+	if p.Before < 0 || p.After < 0 || p.Around < 0 || p.Limit < 0 {
+		return service.HistoryQuery{}, utils.NewBadRequestError("before, after, around and limit must be positive")
+	}
+
+	query := service.HistoryQuery{
+		Before: p.Before,
+		After:  p.After,
+		Around: p.Around,
+		Limit:  p.Limit,
+	}
+	if p.At != "" {
+		loc, err := export.ParseLocation(p.Tz)
+		if err != nil {
+			return service.HistoryQuery{}, utils.NewBadRequestError(err.Error())
+		}
+		if query.At, err = service.ParseHistoryTime(p.At, loc); err != nil {
+			return service.HistoryQuery{}, utils.NewBadRequestError("invalid at: " + err.Error())
+		}
+	}
+	return query, nil
+}
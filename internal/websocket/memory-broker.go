@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBroker is an in-process Broker. It is enough for a single replica, and
+// several gateways sharing one MemoryBroker behave like replicas behind a real one.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[string]map[int]func(payload []byte)
+}
+
+// NewMemoryBroker creates an empty in-process broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string]map[int]func(payload []byte)),
+	}
+}
+
+// Publish calls every handler subscribed to the topic. Handlers run outside the
+// broker's lock so they may subscribe or publish themselves.
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.RLock()
+	handlers := make([]func(payload []byte), 0, len(b.handlers[topic]))
+	for _, handler := range b.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for the topic.
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	if b.handlers[topic] == nil {
+		b.handlers[topic] = make(map[int]func(payload []byte))
+	}
+	b.handlers[topic][id] = handler
+
+	return func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers[topic], id)
+		if len(b.handlers[topic]) == 0 {
+			delete(b.handlers, topic)
+		}
+		return nil
+	}, nil
+}
+
+// Close drops every subscription.
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = make(map[string]map[int]func(payload []byte))
+	return nil
+}
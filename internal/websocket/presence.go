@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// userConnected is called once a client is registered. The first connection of a
+// user, on any replica, brings them online and is announced to their contacts.
+func (ws *MyWebSocketServer) userConnected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first, err := ws.presenceService.Connected(ctx, client.userID, client.id)
+	logError("Error recording connection", err)
+	if first {
+		ws.announcePresence(model.Presence{UserId: client.userID, Online: true})
+	}
+}
+
+// userDisconnected is called once a client is unregistered. When the user's last
+// connection drops, lastSeenAt is saved and the user is announced as offline.
+func (ws *MyWebSocketServer) userDisconnected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	presence, err := ws.presenceService.Disconnected(ctx, client.userID, client.id)
+	logError("Error saving last seen", err)
+	if presence != nil {
+		ws.announcePresence(*presence)
+	}
+}
+
+// refreshPresence keeps the connections open on this replica live in the presence
+// store, so they outlast service.PresenceTTL. It gets a snapshot of the registry
+// taken by the hub and runs on its own goroutine.
+func (ws *MyWebSocketServer) refreshPresence(connections map[primitive.ObjectID][]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for userID, connectionIDs := range connections {
+		logError("Error refreshing presence", ws.presenceService.Refresh(ctx, userID, connectionIDs))
+	}
+}
+
+// announcePresence pushes a presence event to every user who shares a conversation with the user.
+func (ws *MyWebSocketServer) announcePresence(presence model.Presence) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	contacts, err := ws.presenceService.Contacts(ctx, presence.UserId)
+	if err != nil {
+		logError("Error loading contacts", err)
+		return
+	}
+	if len(contacts) == 0 {
+		return
+	}
+	logError("Error delivering presence", ws.deliverToUsers(contacts, TypePresence, presence, nil))
+}
@@ -0,0 +1,405 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/service"
+	"simple-chat-app/internal/utils"
+)
+
+/**
+The gateway speaks a small JSON protocol. Every frame, in both directions, is an Envelope:
+
+	{"type": "send_message", "id": "c1", "version": 1, "payload": {...}}
+
+type:    the action requested by the client, or the kind of frame sent by the server.
+id:      an opaque request ID chosen by the client. The server echoes it on the ack or
+         error frame answering that request. Server-initiated events carry no id.
+version: the protocol version the frame was written for. A missing version means 1.
+payload: the action-specific body, described by the *Payload structs below.
+silent:  set on server events that should not alert the user, such as a message in a
+         conversation they muted.
+
+Every client request is answered with exactly one "ack" frame, whose payload is the
+result of the action, or one "error" frame, whose payload is an ErrorPayload.
+*/
+
+// ProtocolVersion is the newest protocol version the gateway understands.
+const ProtocolVersion = 1
+
+// Frame types sent by the client.
+const (
+	TypeCreateConversation = "create_conversation"
+	TypeGetConversation    = "get_conversationById"
+	TypeSendMessage        = "send_message"
+	TypeTypingStart        = "typing_start"
+	TypeTypingStop         = "typing_stop"
+	TypeMarkRead           = "mark_read"
+	TypeResume             = "resume"
+	TypeCreateGroup        = "create_group"
+	TypeAddParticipants    = "add_participants"
+	TypeRemoveParticipant  = "remove_participant"
+	TypeLeaveConversation  = "leave_conversation"
+	TypeSetRole            = "set_role"
+	TypeUpdateSettings     = "update_settings"
+	TypeSetModeration      = "set_moderation"
+	TypeMuteMember         = "mute_member"
+	TypeGetMessages        = "get_messages"
+	TypeEditMessage        = "edit_message"
+	TypeDeleteMessage      = "delete_message"
+	TypeGetThread          = "get_thread"
+	TypeFollowThread       = "follow_thread"
+	TypeAddReaction        = "add_reaction"
+	TypeRemoveReaction     = "remove_reaction"
+)
+
+// Frame types sent by the server.
+const (
+	TypeAck        = "ack"
+	TypeError      = "error"
+	TypeMessage    = "message"
+	TypePresence   = "presence"
+	TypeTyping     = "typing"
+	TypeReceipt    = "receipt"
+	TypeMembership = "membership"
+	TypeSettings   = "settings"
+	TypeEdited     = "message_edited"
+	TypeDeleted    = "message_deleted"
+	TypeReacted    = "reaction_added"
+	TypeUnreacted  = "reaction_removed"
+	TypeMention    = "mention"
+)
+
+// Envelope wraps every frame exchanged over the gateway.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Silent  bool            `json:"silent,omitempty"`
+}
+
+// CreateConversationPayload is the payload of a create_conversation request.
+// SenderId is optional and, when present, must be the authenticated user.
+type CreateConversationPayload struct {
+	SenderId   primitive.ObjectID `json:"senderId,omitempty"`
+	ReceiverId primitive.ObjectID `json:"receiverId"`
+}
+
+// GetConversationPayload is the payload of a get_conversationById request.
+type GetConversationPayload struct {
+	ID primitive.ObjectID `json:"id"`
+}
+
+// SendMessagePayload is the payload of a send_message request.
+// SenderId is optional and, when present, must be the authenticated user.
+// ParentId makes the message a reply in the thread of that message.
+type SendMessagePayload struct {
+	ConversationId primitive.ObjectID  `json:"conversationId"`
+	SenderId       primitive.ObjectID  `json:"senderId,omitempty"`
+	Message        string              `json:"message"`
+	ParentId       *primitive.ObjectID `json:"parentId,omitempty"`
+}
+
+// TypingPayload is the payload of the typing_start and typing_stop requests.
+type TypingPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+}
+
+// TypingEvent tells the other participants that a user started or stopped typing.
+// Clients should also drop an indicator that is not refreshed within a few seconds.
+type TypingEvent struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	UserId         primitive.ObjectID `json:"userId"`
+	Typing         bool               `json:"typing"`
+}
+
+// MarkReadPayload is the payload of a mark_read request: the conversation has been
+// read up to and including MessageId.
+type MarkReadPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+}
+
+// PageCursor selects a page of messages. Before, After and Around are sequence
+// numbers to page from; At jumps to a date or RFC 3339 time, read in the Tz time zone.
+type PageCursor struct {
+	Before int64  `json:"before,omitempty"`
+	After  int64  `json:"after,omitempty"`
+	Around int64  `json:"around,omitempty"`
+	At     string `json:"at,omitempty"`
+	Tz     string `json:"tz,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// GetMessagesPayload is the payload of a get_messages request.
+// The ack carries a service.MessagePage.
+type GetMessagesPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	PageCursor
+}
+
+// EditMessagePayload is the payload of an edit_message request: the new text of one
+// of the client's messages. The ack carries the edited message.
+type EditMessagePayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	Message        string             `json:"message"`
+}
+
+// DeleteMessagePayload is the payload of a delete_message request. Without
+// ForEveryone, the message is only hidden from the client's user.
+type DeleteMessagePayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	ForEveryone    bool               `json:"forEveryone,omitempty"`
+}
+
+// MessageDeletedEvent tells the participants that a message was deleted for everyone,
+// or a user's other devices that they deleted it for themselves.
+type MessageDeletedEvent struct {
+	ConversationId primitive.ObjectID  `json:"conversationId"`
+	MessageId      primitive.ObjectID  `json:"messageId"`
+	Seq            int64               `json:"seq"`
+	ForEveryone    bool                `json:"forEveryone"`
+	DeletedBy      *primitive.ObjectID `json:"deletedBy,omitempty"`
+}
+
+// GetThreadPayload is the payload of a get_thread request: a page of the replies in
+// the thread of MessageId. The ack carries a service.ThreadPage.
+type GetThreadPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	PageCursor
+}
+
+// FollowThreadPayload is the payload of a follow_thread request. Follow false
+// stops following the thread.
+type FollowThreadPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	Follow         bool               `json:"follow"`
+}
+
+// ReactionPayload is the payload of the add_reaction and remove_reaction requests.
+// The ack and the reaction_added and reaction_removed events carry a service.ReactionChange.
+type ReactionPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	Emoji          string             `json:"emoji"`
+}
+
+// MentionEvent tells a user that a new message mentions them, by name or, as told
+// by Broadcast, with @here or @all.
+type MentionEvent struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	SenderId       primitive.ObjectID `json:"senderId"`
+	Seq            int64              `json:"seq"`
+	Broadcast      string             `json:"broadcast,omitempty"`
+}
+
+// ResumePayload is the payload of a resume request: the last sequence number the
+// client has seen in each conversation it wants to catch up on.
+type ResumePayload struct {
+	Conversations []ResumeCursor `json:"conversations"`
+}
+
+// ResumeCursor is the replay position of one conversation. In a resume ack, LastSeq
+// is the last replayed sequence number and HasMore tells the client to resume again
+// from there because the replay limit was reached. Error is set when the
+// conversation could not be replayed.
+type ResumeCursor struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	LastSeq        int64              `json:"lastSeq"`
+	HasMore        bool               `json:"hasMore,omitempty"`
+	Error          *ErrorPayload      `json:"error,omitempty"`
+}
+
+// ResumeResult is the ack payload of a resume request.
+type ResumeResult struct {
+	Conversations []ResumeCursor `json:"conversations"`
+}
+
+// ReceiptEvent tells a sender that a recipient received or read their messages.
+// A "delivered" receipt is about MessageId alone; a "read" receipt covers every
+// message of the conversation up to and including MessageId.
+type ReceiptEvent struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	MessageId      primitive.ObjectID `json:"messageId"`
+	UserId         primitive.ObjectID `json:"userId"`
+	Status         string             `json:"status"`
+	At             time.Time          `json:"at"`
+}
+
+// ConversationResult is the ack payload of a get_conversationById request.
+type ConversationResult struct {
+	Conversation *model.Conversation `json:"conversation"`
+	Users        []model.UserProfile `json:"users"`
+}
+
+// CreateGroupPayload is the payload of a create_group request. The authenticated
+// user becomes the owner of the group.
+type CreateGroupPayload struct {
+	Title   string               `json:"title"`
+	Avatar  string               `json:"avatar,omitempty"`
+	UserIds []primitive.ObjectID `json:"userIds"`
+}
+
+// AddParticipantsPayload is the payload of an add_participants request.
+type AddParticipantsPayload struct {
+	ConversationId primitive.ObjectID   `json:"conversationId"`
+	UserIds        []primitive.ObjectID `json:"userIds"`
+}
+
+// RemoveParticipantPayload is the payload of a remove_participant request.
+type RemoveParticipantPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	UserId         primitive.ObjectID `json:"userId"`
+}
+
+// LeaveConversationPayload is the payload of a leave_conversation request.
+type LeaveConversationPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+}
+
+// SetRolePayload is the payload of a set_role request.
+type SetRolePayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	UserId         primitive.ObjectID `json:"userId"`
+	Role           string             `json:"role"`
+}
+
+// UpdateSettingsPayload is the payload of an update_settings request. Omitted
+// settings are left as they are.
+type UpdateSettingsPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	service.SettingsUpdate
+}
+
+// SetModerationPayload is the payload of a set_moderation request. Omitted rules
+// are left as they are.
+type SetModerationPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	service.ModerationUpdate
+}
+
+// MuteMemberPayload is the payload of a mute_member request. Duration is a Go
+// duration such as "1h"; "0s" unmutes.
+type MuteMemberPayload struct {
+	ConversationId primitive.ObjectID `json:"conversationId"`
+	UserId         primitive.ObjectID `json:"userId"`
+	Duration       string             `json:"duration"`
+}
+
+// SettingsEvent tells a user's other devices that their settings for a conversation changed.
+type SettingsEvent struct {
+	ConversationId primitive.ObjectID         `json:"conversationId"`
+	Settings       *model.ParticipantSettings `json:"settings"`
+}
+
+// Membership actions reported by a MembershipEvent.
+const (
+	MembershipCreated     = "created"
+	MembershipAdded       = "added"
+	MembershipRemoved     = "removed"
+	MembershipLeft        = "left"
+	MembershipJoined      = "joined"
+	MembershipMuted       = "muted"
+	MembershipModeration  = "moderation_changed"
+	MembershipRoleChanged = "role_changed"
+)
+
+// MembershipEvent tells the participants of a group, and the users it is about,
+// that its membership changed. Conversation is the group after the change.
+type MembershipEvent struct {
+	ConversationId primitive.ObjectID   `json:"conversationId"`
+	Action         string               `json:"action"`
+	ActorId        primitive.ObjectID   `json:"actorId"`
+	UserIds        []primitive.ObjectID `json:"userIds"`
+	Conversation   *model.Conversation  `json:"conversation"`
+}
+
+// ErrorPayload is the payload of an error frame. Code is stable and safe to branch on;
+// Message is meant for humans and may change.
+// RetryAt, when set, is when the request may succeed if retried.
+type ErrorPayload struct {
+	Code           string     `json:"code"`
+	Message        string     `json:"message"`
+	HTTPStatusCode int        `json:"httpStatusCode"`
+	RetryAt        *time.Time `json:"retryAt,omitempty"`
+}
+
+// decodeEnvelope parses a raw client frame and checks its version.
+func decodeEnvelope(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, utils.NewBadRequestError(fmt.Sprintf("invalid frame: %v", err))
+	}
+	if env.Version == 0 {
+		env.Version = 1
+	}
+	if env.Version > ProtocolVersion {
+		return &env, utils.NewBadRequestError(fmt.Sprintf("unsupported protocol version %d", env.Version))
+	}
+	if env.Type == "" {
+		return &env, utils.NewBadRequestError("missing frame type")
+	}
+	return &env, nil
+}
+
+// decodePayload unmarshals the envelope payload into the action-specific struct.
+func decodePayload(env *Envelope, v interface{}) error {
+	if len(env.Payload) == 0 {
+		return utils.NewBadRequestError("missing payload")
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return utils.NewBadRequestError(fmt.Sprintf("invalid %s payload: %v", env.Type, err))
+	}
+	return nil
+}
+
+// newFrame builds a server frame around the given payload.
+func newFrame(frameType, id string, payload interface{}) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling %s payload: %v", frameType, err)
+	}
+	return &Envelope{
+		Type:    frameType,
+		ID:      id,
+		Version: ProtocolVersion,
+		Payload: body,
+	}, nil
+}
+
+// errorPayloadFor turns an error into the payload of an error frame. Only a
+// utils.CustomError is shown to the client as is; anything else is logged and
+// reported as an internal error so no implementation details leak.
+func errorPayloadFor(err error) ErrorPayload {
+	var customErr *utils.CustomError
+	if !errors.As(err, &customErr) {
+		logError("Error processing message", err)
+		customErr = utils.NewInternalServerError("Internal Server Error")
+	}
+	return ErrorPayload{
+		Code:           customErr.Code(),
+		Message:        customErr.Message,
+		HTTPStatusCode: customErr.HTTPStatusCode,
+		RetryAt:        customErr.RetryAt,
+	}
+}
+
+// logFrameError logs why a frame could not be written back to the client.
+func logFrameError(frameType string, err error) {
+	if err != nil {
+		log.Printf("Error sending %s frame: %v", frameType, err)
+	}
+}
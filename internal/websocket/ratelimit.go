@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket: it holds up to burst tokens and earns one token
+// per interval. Every allowed call spends a token.
+type rateLimiter struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(burst int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// allow spends a token if one is available and reports whether it did.
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"context"
+
+	"simple-chat-app/internal/model"
+	"simple-chat-app/internal/service"
+)
+
+// NotifyReaction tells the participants of a conversation that a reaction was added
+// to or removed from a message. It is used by the REST API so that reactions made
+// there reach connected clients.
+func (ws *MyWebSocketServer) NotifyReaction(conversation *model.Conversation, change *service.ReactionChange, added bool) error {
+	return ws.deliverReaction(conversation, change, added, nil)
+}
+
+// handleReaction processes a request to add or remove a reaction on a message.
+func (ws *MyWebSocketServer) handleReaction(ctx context.Context, client *Client, env *Envelope, add bool) (interface{}, error) {
+	var payload ReactionPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var change *service.ReactionChange
+	if add {
+		change, err = ws.messageService.AddReaction(ctx, conversation, payload.MessageId, client.userID, payload.Emoji)
+	} else {
+		change, err = ws.messageService.RemoveReaction(ctx, conversation, payload.MessageId, client.userID, payload.Emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logError("Error delivering reaction", ws.deliverReaction(conversation, change, add, client))
+	return change, nil
+}
+
+// deliverReaction publishes a reaction change to the participants, unless it changed nothing.
+func (ws *MyWebSocketServer) deliverReaction(conversation *model.Conversation, change *service.ReactionChange, added bool, exclude *Client) error {
+	if !change.Changed {
+		return nil
+	}
+	eventType := TypeUnreacted
+	if added {
+		eventType = TypeReacted
+	}
+	return ws.deliverToUsers(conversation.ParticipantIDs(), eventType, change, exclude)
+}
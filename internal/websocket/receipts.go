@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// deliveredReceipt is a message that was written to one of a recipient's connections.
+type deliveredReceipt struct {
+	conversationID primitive.ObjectID
+	messageID      primitive.ObjectID
+	senderID       primitive.ObjectID
+	userID         primitive.ObjectID
+}
+
+// queueDelivered hands a delivered message to the receipts worker without blocking
+// the write pump that reported it.
+func (ws *MyWebSocketServer) queueDelivered(receipt deliveredReceipt) {
+	select {
+	case ws.delivered <- receipt:
+	default:
+		log.Printf("Dropping delivery receipt of message %s for user %s", receipt.messageID.Hex(), receipt.userID.Hex())
+	}
+}
+
+// handleReceipts saves delivery receipts and tells the sender about each one.
+// A message is delivered to a user once, however many devices receive it.
+// It returns, closing receiptsDone, once the delivered queue is closed and drained.
+func (ws *MyWebSocketServer) handleReceipts() {
+	defer close(ws.receiptsDone)
+
+	for receipt := range ws.delivered {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		saved, err := ws.messageService.MarkDelivered(ctx, receipt.messageID, receipt.userID)
+		cancel()
+		if err != nil {
+			logError("Error saving delivery receipt", err)
+			continue
+		}
+		if saved == nil {
+			continue
+		}
+
+		event := &ReceiptEvent{
+			ConversationId: receipt.conversationID,
+			MessageId:      receipt.messageID,
+			UserId:         receipt.userID,
+			Status:         model.MessageStatusDelivered,
+			At:             saved.DeliveredAt,
+		}
+		logError("Error delivering receipt", ws.deliverToUsers([]primitive.ObjectID{receipt.senderID}, TypeReceipt, event, nil))
+	}
+}
+
+// handleMarkRead moves the user's last-read pointer up to a message and tells the
+// senders of the newly read messages, as well as the user's other devices.
+func (ws *MyWebSocketServer) handleMarkRead(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload MarkReadPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	if _, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID); err != nil {
+		return nil, err
+	}
+
+	result, err := ws.messageService.MarkRead(ctx, payload.ConversationId, client.userID, payload.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &ReceiptEvent{
+		ConversationId: payload.ConversationId,
+		MessageId:      payload.MessageId,
+		UserId:         client.userID,
+		Status:         model.MessageStatusRead,
+	}
+	if result == nil {
+		// Already read up to here; nothing to announce
+		return event, nil
+	}
+	event.At = result.ReadAt
+
+	recipients := append(excludeUser(result.Senders, client.userID), client.userID)
+	logError("Error delivering receipt", ws.deliverToUsers(recipients, TypeReceipt, event, client))
+	return event, nil
+}
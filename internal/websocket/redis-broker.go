@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker is a Broker on top of Redis pub/sub, for running several replicas.
+// Each replica keeps a single pub/sub connection and multiplexes its topics on it.
+type RedisBroker struct {
+	client redis.UniversalClient
+	prefix string
+	pubsub *redis.PubSub
+
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[string]map[int]func(payload []byte)
+	done     chan struct{}
+}
+
+// NewRedisBroker creates a broker publishing on the given client. Topics are
+// namespaced with prefix so several deployments can share a Redis server.
+func NewRedisBroker(client redis.UniversalClient, prefix string) *RedisBroker {
+	b := &RedisBroker{
+		client:   client,
+		prefix:   prefix,
+		pubsub:   client.Subscribe(context.Background()),
+		handlers: make(map[string]map[int]func(payload []byte)),
+		done:     make(chan struct{}),
+	}
+	go b.dispatch()
+	return b
+}
+
+// Publish sends the payload to the topic's Redis channel.
+func (b *RedisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.Publish(ctx, b.prefix+topic, payload).Err()
+}
+
+// Subscribe registers a handler for the topic, subscribing to its Redis channel
+// when it is the first handler of the topic on this replica.
+func (b *RedisBroker) Subscribe(ctx context.Context, topic string, handler func(payload []byte)) (func() error, error) {
+	channel := b.prefix + topic
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.handlers[channel]) == 0 {
+		if err := b.pubsub.Subscribe(ctx, channel); err != nil {
+			return nil, err
+		}
+		b.handlers[channel] = make(map[int]func(payload []byte))
+	}
+	id := b.nextID
+	b.nextID++
+	b.handlers[channel][id] = handler
+
+	return func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers[channel], id)
+		if len(b.handlers[channel]) > 0 {
+			return nil
+		}
+		delete(b.handlers, channel)
+		return b.pubsub.Unsubscribe(context.Background(), channel)
+	}, nil
+}
+
+// Close stops dispatching and closes the pub/sub connection.
+func (b *RedisBroker) Close() error {
+	close(b.done)
+	return b.pubsub.Close()
+}
+
+// dispatch hands every message received on the pub/sub connection to the handlers
+// of its channel.
+func (b *RedisBroker) dispatch() {
+	messages := b.pubsub.Channel()
+	for {
+		select {
+		case <-b.done:
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			b.mu.RLock()
+			handlers := make([]func(payload []byte), 0, len(b.handlers[message.Channel]))
+			for _, handler := range b.handlers[message.Channel] {
+				handlers = append(handlers, handler)
+			}
+			b.mu.RUnlock()
+
+			for _, handler := range handlers {
+				handler([]byte(message.Payload))
+			}
+		}
+	}
+}
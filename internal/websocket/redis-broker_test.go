@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testPrefix = "test:"
+
+// newTestRedisBroker creates a RedisBroker on its own connection to the server.
+func newTestRedisBroker(t *testing.T, server *miniredis.Miniredis) *RedisBroker {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	broker := NewRedisBroker(client, testPrefix)
+	t.Cleanup(func() {
+		broker.Close()
+		client.Close()
+	})
+	return broker
+}
+
+// waitForSubscribers waits until the topic has the given number of Redis subscribers.
+func waitForSubscribers(t *testing.T, server *miniredis.Miniredis, topic string, want int) {
+	t.Helper()
+
+	channel := testPrefix + topic
+	deadline := time.Now().Add(5 * time.Second)
+	for server.PubSubNumSub(channel)[channel] != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("topic %s has %d subscribers, want %d", topic, server.PubSubNumSub(channel)[channel], want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receive waits for the next payload on the channel.
+func receive(t *testing.T, payloads <-chan []byte) string {
+	t.Helper()
+
+	select {
+	case payload := <-payloads:
+		return string(payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a payload")
+		return ""
+	}
+}
+
+func TestRedisBrokerPublishSubscribe(t *testing.T) {
+	server := miniredis.RunT(t)
+	broker := newTestRedisBroker(t, server)
+	ctx := context.Background()
+
+	first := make(chan []byte, 1)
+	second := make(chan []byte, 1)
+	unsubscribeFirst, err := broker.Subscribe(ctx, "topic", func(payload []byte) { first <- payload })
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	unsubscribeSecond, err := broker.Subscribe(ctx, "topic", func(payload []byte) { second <- payload })
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	// Both handlers share one Redis subscription
+	waitForSubscribers(t, server, "topic", 1)
+
+	if err := broker.Publish(ctx, "topic", []byte("hello")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := receive(t, first); got != "hello" {
+		t.Fatalf("first handler got %q, want %q", got, "hello")
+	}
+	if got := receive(t, second); got != "hello" {
+		t.Fatalf("second handler got %q, want %q", got, "hello")
+	}
+
+	// The topic stays subscribed while a handler is left
+	if err := unsubscribeFirst(); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	waitForSubscribers(t, server, "topic", 1)
+	if err := broker.Publish(ctx, "topic", []byte("again")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := receive(t, second); got != "again" {
+		t.Fatalf("second handler got %q, want %q", got, "again")
+	}
+	select {
+	case payload := <-first:
+		t.Fatalf("unsubscribed handler got %q", payload)
+	default:
+	}
+
+	if err := unsubscribeSecond(); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	waitForSubscribers(t, server, "topic", 0)
+}
+
+func TestRedisBrokerResubscribesAfterReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	broker := newTestRedisBroker(t, server)
+	ctx := context.Background()
+
+	payloads := make(chan []byte, 16)
+	if _, err := broker.Subscribe(ctx, "topic", func(payload []byte) { payloads <- payload }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	waitForSubscribers(t, server, "topic", 1)
+
+	// Dropping every connection loses the server side subscription
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	waitForSubscribers(t, server, "topic", 1)
+
+	if err := broker.Publish(ctx, "topic", []byte("after restart")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := receive(t, payloads); got != "after restart" {
+		t.Fatalf("got %q, want %q", got, "after restart")
+	}
+}
+
+func TestDeliveryAcrossReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicaA := NewWebSocketServer(nil, nil, nil, newTestRedisBroker(t, server), "", DefaultConfig())
+	replicaB := NewWebSocketServer(nil, nil, nil, newTestRedisBroker(t, server), "", DefaultConfig())
+	replicaA.Start()
+	replicaB.Start()
+
+	// The client is connected to replica B only
+	client, peer := newTestClient(t, DefaultConfig())
+	replicaB.register <- client
+	waitForSubscribers(t, server, UserTopic(client.userID), 1)
+
+	conversationID := primitive.NewObjectID()
+	d, err := newDelivery(TypeTyping, TypingEvent{ConversationId: conversationID, UserId: primitive.NewObjectID(), Typing: true}, nil, false)
+	if err != nil {
+		t.Fatalf("new delivery: %v", err)
+	}
+	if err := replicaA.publish([]primitive.ObjectID{client.userID}, d); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := peer.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	var event TypingEvent
+	if err := json.Unmarshal(env.Payload, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if env.Type != TypeTyping || event.ConversationId != conversationID {
+		t.Fatalf("got %s frame for conversation %s, want %s for %s", env.Type, event.ConversationId.Hex(), TypeTyping, conversationID.Hex())
+	}
+}
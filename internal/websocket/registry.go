@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// registry indexes connected clients by the user they are authenticated as.
+// A user can be connected from several devices at once, so each user maps to a set of clients.
+// The registry is owned by the hub goroutine and is not safe for concurrent use.
+type registry struct {
+	users map[primitive.ObjectID]map[*Client]bool
+}
+
+func newRegistry() *registry {
+	return &registry{
+		users: make(map[primitive.ObjectID]map[*Client]bool),
+	}
+}
+
+// add registers a client under its user and reports whether it is the user's
+// first client on this replica.
+func (r *registry) add(client *Client) bool {
+	clients, ok := r.users[client.userID]
+	if !ok {
+		clients = make(map[*Client]bool)
+		r.users[client.userID] = clients
+	}
+	clients[client] = true
+	return !ok
+}
+
+// remove unregisters a client. It reports whether the client was registered and
+// whether it was the user's last client on this replica.
+func (r *registry) remove(client *Client) (removed bool, last bool) {
+	clients, ok := r.users[client.userID]
+	if !ok || !clients[client] {
+		return false, false
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(r.users, client.userID)
+		return true, true
+	}
+	return true, false
+}
+
+// each calls fn for every registered client.
+func (r *registry) each(fn func(client *Client)) {
+	for _, clients := range r.users {
+		for client := range clients {
+			fn(client)
+		}
+	}
+}
+
+// clientsOf returns the connected clients of a user.
+func (r *registry) clientsOf(userID primitive.ObjectID) map[*Client]bool {
+	return r.users[userID]
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// handleResume replays the messages a reconnecting client missed. The client sends
+// the last sequence number it has seen in each conversation; every later message is
+// sent as a regular message event, oldest first, before live delivery resumes.
+// A conversation that cannot be replayed, such as one the user left, is reported
+// with an error in the ack and does not stop the others.
+// Live messages arriving during the replay are held back and sent afterwards,
+// skipping any the replay already covered. The replay waits for room in the send
+// queue rather than overflowing it, and the ack only goes out once every replayed
+// message was written, so the held back messages then fit in the queue.
+func (ws *MyWebSocketServer) handleResume(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload ResumePayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	client.beginResume()
+	replayed := make(map[primitive.ObjectID]int64, len(payload.Conversations))
+	defer func() { client.endResume(replayed) }()
+
+	var written <-chan struct{}
+	result := &ResumeResult{Conversations: []ResumeCursor{}}
+	for _, cursor := range payload.Conversations {
+		if _, err := ws.conversationService.Authorize(ctx, cursor.ConversationId, client.userID); err != nil {
+			result.Conversations = append(result.Conversations, failedResume(cursor, err))
+			continue
+		}
+
+		limit := ws.config.ResumeReplayLimit
+		messages, err := ws.messageService.ListAfter(ctx, cursor.ConversationId, client.userID, cursor.LastSeq, limit+1)
+		if err != nil {
+			result.Conversations = append(result.Conversations, failedResume(cursor, err))
+			continue
+		}
+
+		hasMore := int64(len(messages)) > limit
+		if hasMore {
+			messages = messages[:limit]
+		}
+
+		lastSeq, lastWritten, err := client.replay(ctx, messages, cursor.LastSeq)
+		if err != nil {
+			return nil, err
+		}
+		if lastWritten != nil {
+			written = lastWritten
+		}
+		replayed[cursor.ConversationId] = lastSeq
+
+		result.Conversations = append(result.Conversations, ResumeCursor{
+			ConversationId: cursor.ConversationId,
+			LastSeq:        lastSeq,
+			HasMore:        hasMore,
+		})
+	}
+
+	if written != nil {
+		select {
+		case <-written:
+		case <-client.done:
+			return nil, errClientClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return result, nil
+}
+
+// failedResume reports a conversation that could not be replayed; the others still are.
+func failedResume(cursor ResumeCursor, err error) ResumeCursor {
+	payload := errorPayloadFor(err)
+	return ResumeCursor{
+		ConversationId: cursor.ConversationId,
+		LastSeq:        cursor.LastSeq,
+		Error:          &payload,
+	}
+}
+
+// replay queues messages for the client as message events, oldest first, waiting for
+// room in the send queue so a long replay is never cut short by the SlowConsumerPolicy.
+// It returns the sequence number of the last message queued, or lastSeq if there were
+// none, and a channel closed once that message is written to the connection.
+func (c *Client) replay(ctx context.Context, messages []model.Message, lastSeq int64) (int64, <-chan struct{}, error) {
+	var written chan struct{}
+	for i := range messages {
+		frame, err := newFrame(TypeMessage, "", &messages[i])
+		if err != nil {
+			return lastSeq, nil, err
+		}
+		data, err := json.Marshal(frame)
+		if err != nil {
+			return lastSeq, nil, fmt.Errorf("error marshalling %s frame: %v", frame.Type, err)
+		}
+
+		message := outbound{data: data}
+		if i == len(messages)-1 {
+			written = make(chan struct{})
+			message.onWritten = func() { close(written) }
+		}
+		if err := c.enqueueWait(ctx, message); err != nil {
+			return lastSeq, nil, err
+		}
+		lastSeq = messages[i].Seq
+	}
+	return lastSeq, written, nil
+}
+
+// beginResume holds back live deliveries to the client until endResume.
+func (c *Client) beginResume() {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	c.resuming = true
+}
+
+// endResume releases the held back deliveries, dropping messages whose sequence
+// number was already replayed for their conversation.
+func (c *Client) endResume(replayed map[primitive.ObjectID]int64) {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	for _, message := range c.held {
+		if lastSeq, ok := replayed[message.conversationID]; ok && message.seq != 0 && message.seq <= lastSeq {
+			continue
+		}
+		c.enqueue(message)
+	}
+	c.held = nil
+	c.resuming = false
+}
+
+// deliver queues a live delivery for the client, or holds it back while the client
+// is resuming. At most a send queue's worth of frames is held back; beyond that
+// frames go straight to the queue and the client must rely on sequence numbers.
+func (c *Client) deliver(message outbound) bool {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	if !c.resuming {
+		return c.enqueue(message)
+	}
+	if len(c.held) >= c.config.SendQueueSize {
+		return c.enqueue(message)
+	}
+	c.held = append(c.held, message)
+	return true
+}
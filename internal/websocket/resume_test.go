@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// newTestClient serves a single gateway client over a real WebSocket connection
+// and returns it with the connection of the peer reading from it.
+func newTestClient(t *testing.T, config Config) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		client := newClient(conn, primitive.NewObjectID(), config)
+		go client.writePump()
+		clients <- client
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	client := <-clients
+	t.Cleanup(func() { client.close(0, "") })
+	return client, peer
+}
+
+func TestReplayLongerThanSendQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.SendQueueSize = 4
+	config.SlowConsumerPolicy = SlowConsumerClose
+	client, peer := newTestClient(t, config)
+
+	conversationID := primitive.NewObjectID()
+	messages := make([]model.Message, 5*config.SendQueueSize)
+	for i := range messages {
+		messages[i] = model.Message{ID: primitive.NewObjectID(), ConversationId: conversationID, Seq: int64(i + 1)}
+	}
+
+	// Read slowly on the other end, so the send queue fills up during the replay
+	received := make(chan int64, len(messages))
+	go func() {
+		for range messages {
+			time.Sleep(time.Millisecond)
+			_, data, err := peer.ReadMessage()
+			if err != nil {
+				close(received)
+				return
+			}
+			var env Envelope
+			var message model.Message
+			if json.Unmarshal(data, &env) != nil || json.Unmarshal(env.Payload, &message) != nil {
+				close(received)
+				return
+			}
+			received <- message.Seq
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lastSeq, written, err := client.replay(ctx, messages, 0)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if lastSeq != int64(len(messages)) {
+		t.Fatalf("lastSeq = %d, want %d", lastSeq, len(messages))
+	}
+
+	select {
+	case <-written:
+	case <-ctx.Done():
+		t.Fatal("the last replayed message was never written")
+	}
+
+	for want := int64(1); want <= int64(len(messages)); want++ {
+		select {
+		case seq, ok := <-received:
+			if !ok {
+				t.Fatalf("connection failed after %d messages", want-1)
+			}
+			if seq != want {
+				t.Fatalf("got seq %d, want %d", seq, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+
+	select {
+	case <-client.done:
+		t.Fatal("the client was disconnected as a slow consumer")
+	default:
+	}
+}
+
+func TestReplayStopsWhenClientCloses(t *testing.T) {
+	config := DefaultConfig()
+	config.SendQueueSize = 1
+	client, _ := newTestClient(t, config)
+	client.close(0, "")
+
+	messages := []model.Message{{Seq: 1}, {Seq: 2}, {Seq: 3}}
+	if _, _, err := client.replay(context.Background(), messages, 0); err != errClientClosed {
+		t.Fatalf("replay on a closed client: err = %v, want %v", err, errClientClosed)
+	}
+}
@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/model"
+)
+
+// NotifySettings tells every connected device of a user that their settings for a
+// conversation changed. It is used by the REST API so that changes made there
+// reach connected clients.
+func (ws *MyWebSocketServer) NotifySettings(userID, conversationID primitive.ObjectID, settings *model.ParticipantSettings) error {
+	event := &SettingsEvent{ConversationId: conversationID, Settings: settings}
+	return ws.deliverToUsers([]primitive.ObjectID{userID}, TypeSettings, event, nil)
+}
+
+// handleUpdateSettings processes a request to change the client's user settings for a
+// conversation, and syncs the change to the user's other devices
+func (ws *MyWebSocketServer) handleUpdateSettings(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload UpdateSettingsPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	settings, err := ws.conversationService.UpdateSettings(ctx, payload.ConversationId, client.userID, payload.SettingsUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &SettingsEvent{ConversationId: payload.ConversationId, Settings: settings}
+	logError("Error delivering settings", ws.deliverToUsers([]primitive.ObjectID{client.userID}, TypeSettings, event, client))
+	return event, nil
+}
@@ -0,0 +1,23 @@
+package websocket
+
+import (
+	"context"
+)
+
+// handleFollowThread processes a request to follow or stop following a thread.
+func (ws *MyWebSocketServer) handleFollowThread(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload FollowThreadPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.messageService.FollowThread(ctx, conversation, payload.MessageId, client.userID, payload.Follow); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"simple-chat-app/internal/utils"
+)
+
+// typingIndicator is an active typing_start of a client in one conversation.
+// It expires on its own unless refreshed by another typing_start.
+type typingIndicator struct {
+	timer      *time.Timer
+	recipients []primitive.ObjectID
+}
+
+// handleTypingStart tells the other participants that the user started typing.
+// Indicators are never persisted; they live on the client until stopped or expired.
+func (ws *MyWebSocketServer) handleTypingStart(ctx context.Context, client *Client, env *Envelope) (interface{}, error) {
+	var payload TypingPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	if !client.typingLimiter.allow() {
+		return nil, utils.NewTooManyRequestsError("too many typing indicators, slow down")
+	}
+
+	conversation, err := ws.conversationService.Authorize(ctx, payload.ConversationId, client.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := excludeUser(conversation.ParticipantIDs(), client.userID)
+	client.startTyping(payload.ConversationId, recipients, ws.config.TypingTimeout, func() {
+		ws.sendTyping(client.userID, payload.ConversationId, recipients, false)
+	})
+
+	event := &TypingEvent{ConversationId: payload.ConversationId, UserId: client.userID, Typing: true}
+	logError("Error delivering typing indicator", ws.deliverToUsers(recipients, TypeTyping, event, nil))
+	return event, nil
+}
+
+// handleTypingStop tells the other participants that the user stopped typing.
+func (ws *MyWebSocketServer) handleTypingStop(client *Client, env *Envelope) (interface{}, error) {
+	var payload TypingPayload
+	if err := decodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	if !client.typingLimiter.allow() {
+		return nil, utils.NewTooManyRequestsError("too many typing indicators, slow down")
+	}
+
+	if recipients, ok := client.stopTyping(payload.ConversationId); ok {
+		ws.sendTyping(client.userID, payload.ConversationId, recipients, false)
+	}
+	return &TypingEvent{ConversationId: payload.ConversationId, UserId: client.userID, Typing: false}, nil
+}
+
+// clearTyping stops every indicator of a client that went away.
+func (ws *MyWebSocketServer) clearTyping(client *Client) {
+	for conversationID, recipients := range client.stopAllTyping() {
+		ws.sendTyping(client.userID, conversationID, recipients, false)
+	}
+}
+
+// sendTyping delivers a typing event to the given recipients.
+func (ws *MyWebSocketServer) sendTyping(userID, conversationID primitive.ObjectID, recipients []primitive.ObjectID, typing bool) {
+	event := &TypingEvent{ConversationId: conversationID, UserId: userID, Typing: typing}
+	logError("Error delivering typing indicator", ws.deliverToUsers(recipients, TypeTyping, event, nil))
+}
+
+// startTyping arms, or re-arms, the expiry of the client's indicator in a conversation.
+// expire is called if the indicator is neither refreshed nor stopped within timeout.
+func (c *Client) startTyping(conversationID primitive.ObjectID, recipients []primitive.ObjectID, timeout time.Duration, expire func()) {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	if indicator, ok := c.typing[conversationID]; ok {
+		indicator.timer.Stop()
+	}
+
+	indicator := &typingIndicator{recipients: recipients}
+	indicator.timer = time.AfterFunc(timeout, func() {
+		c.typingMu.Lock()
+		current := c.typing[conversationID] == indicator
+		if current {
+			delete(c.typing, conversationID)
+		}
+		c.typingMu.Unlock()
+
+		if current {
+			expire()
+		}
+	})
+	c.typing[conversationID] = indicator
+}
+
+// stopTyping removes the client's indicator in a conversation.
+// Returns its recipients and whether there was an active indicator.
+func (c *Client) stopTyping(conversationID primitive.ObjectID) ([]primitive.ObjectID, bool) {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	indicator, ok := c.typing[conversationID]
+	if !ok {
+		return nil, false
+	}
+	indicator.timer.Stop()
+	delete(c.typing, conversationID)
+	return indicator.recipients, true
+}
+
+// stopAllTyping removes every indicator of the client and returns their recipients by conversation.
+func (c *Client) stopAllTyping() map[primitive.ObjectID][]primitive.ObjectID {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	stopped := make(map[primitive.ObjectID][]primitive.ObjectID, len(c.typing))
+	for conversationID, indicator := range c.typing {
+		indicator.timer.Stop()
+		stopped[conversationID] = indicator.recipients
+	}
+	c.typing = make(map[primitive.ObjectID]*typingIndicator)
+	return stopped
+}
+
+// excludeUser returns the user IDs without the given user.
+func excludeUser(userIDs []primitive.ObjectID, userID primitive.ObjectID) []primitive.ObjectID {
+	others := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != userID {
+			others = append(others, id)
+		}
+	}
+	return others
+}